@@ -2,6 +2,9 @@ package cache
 
 import (
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-lego/cache/driver"
 )
@@ -11,15 +14,44 @@ type Cache interface {
 	// Options get options
 	Options() Options
 
-	// Init initialize
+	// Init initialize, commits left in journal by a crash are recovered
 	Init() error
 
 	// FlushMemory flush data in memory
 	FlushMemory()
 
-	// BeginTransaction start a transaction if none active
-	BeginTransaction() Transaction
+	// Invalidate drop keys from memory, used when keys are changed outside of the cache
+	Invalidate(keys ...string)
 
+	// BeginTransaction start a transaction, a savepoint nested in the current one if active.
+	// A transaction expired by Timeout, Deadline or WithContext is rolled back once it expires.
+	// The current transaction is shared by every goroutine using the cache, handlers running
+	// concurrently use NewTransaction or RunInTransaction instead.
+	BeginTransaction(opts ...TransOption) Transaction
+
+	// RunInTransaction run fn in a transaction independent of the current one and commit it,
+	// fn is run again up to maxRetries times while the commit fails with ErrConflict.
+	// Commands of fn are run through tx, commands run through the cache are not part of it.
+	RunInTransaction(fn func(tx Transaction) error, maxRetries int) error
+
+	// MemoryStats get counters of memory
+	MemoryStats() MemoryStats
+
+	// TransactionApplied check if transaction of ID was committed, needs TransactionMarkers option
+	TransactionApplied(id string) (bool, error)
+
+	// NewTransaction start a transaction independent of the current one, it is only
+	// used through its own commands and can run concurrently with other transactions.
+	// Cache is safe for concurrent use, but the current transaction is shared by every
+	// goroutine, goroutines needing transactions of their own use NewTransaction.
+	NewTransaction(opts ...TransOption) Transaction
+
+	Commands
+}
+
+// Commands cache commands, run by Cache in the current transaction if any,
+// or by Transaction in the transaction itself
+type Commands interface {
 	// func for keys
 
 	// Get value by key
@@ -40,9 +72,21 @@ type Cache interface {
 	// Check if the given key exists
 	Exists(key string) (bool, error)
 
-	// Expire set key expiration
+	// Expire set key expiration in seconds
 	Expire(key string, ex int64) error
 
+	// PExpire set key expiration in milliseconds
+	PExpire(key string, ms int64) error
+
+	// ExpireDuration set key expiration after duration d
+	ExpireDuration(key string, d time.Duration) error
+
+	// ExpireAt set key expiration at time tm
+	ExpireAt(key string, tm time.Time) error
+
+	// TTL get remaining time to live of key, -1 means no expiration, ErrValueNil if key not exist
+	TTL(key string) (time.Duration, error)
+
 	// Incr increment key
 	Incr(key string, delta interface{}) (string, error)
 
@@ -77,6 +121,65 @@ type Cache interface {
 
 	// HDecr decrement value of hash key
 	HDecr(key string, hk string, delta interface{}) (string, error)
+
+	// HExpire set expiration of hash keys in seconds
+	HExpire(key string, ex int64, hks ...string) error
+
+	// HPExpire set expiration of hash keys in milliseconds
+	HPExpire(key string, ms int64, hks ...string) error
+
+	// HTTL get remaining time to live of hash keys, -1 means no expiration, missing hash keys are omitted
+	HTTL(key string, hks ...string) (map[string]time.Duration, error)
+
+	// HPersist remove expiration of hash keys
+	HPersist(key string, hks ...string) error
+
+	// func for streams
+
+	// XAdd append message to stream, use "*" as id to let server generate it.
+	// Inside a transaction the message is published on commit and the generated id is empty.
+	XAdd(key string, id string, values map[string]interface{}) (string, error)
+
+	// XRange get messages with id between start and end, count <= 0 means no limit
+	XRange(key string, start string, end string, count int64) ([]driver.StreamMessage, error)
+
+	// XRead read messages after the given ids (stream key => id), block > 0 waits up to block for new messages
+	XRead(streams map[string]string, count int64, block time.Duration) ([]driver.Stream, error)
+
+	// XGroupCreate create consumer group starting at id start, mkstream creates the stream if not exist
+	XGroupCreate(key string, group string, start string, mkstream bool) error
+
+	// XReadGroup read messages as consumer of group, use ">" as id to get messages never delivered
+	XReadGroup(group string, consumer string, streams map[string]string, count int64, block time.Duration) ([]driver.Stream, error)
+
+	// XAck acknowledge messages of group
+	XAck(key string, group string, ids ...string) (int64, error)
+
+	// XPending get pending messages of group, empty consumer means all consumers
+	XPending(key string, group string, start string, end string, count int64, consumer string) ([]driver.PendingMessage, error)
+
+	// XClaim take ownership of pending messages idle for at least minIdle
+	XClaim(key string, group string, consumer string, minIdle time.Duration, ids ...string) ([]driver.StreamMessage, error)
+
+	// func for pub/sub
+
+	// Publish post message to channel, returns the number of clients received it.
+	// Inside a transaction the message is published on commit and 0 is returned.
+	Publish(channel string, msg interface{}) (int64, error)
+
+	// Subscribe subscribe channels
+	Subscribe(channels ...string) (driver.Subscription, error)
+
+	// PSubscribe subscribe channels matching patterns
+	PSubscribe(patterns ...string) (driver.Subscription, error)
+
+	// func for scripts
+
+	// Eval run script, keys passed to the script are dropped from memory
+	Eval(script *driver.Script, keysAndArgs ...interface{}) (interface{}, error)
+
+	// EvalSha run script loaded before by its SHA1 hash, keys passed to the script are dropped from memory
+	EvalSha(sha string, keyCount int, keysAndArgs ...interface{}) (interface{}, error)
 }
 
 // NewCache create new cache instance
@@ -86,14 +189,16 @@ func NewCache(opts ...Option) Cache {
 
 const flagValueNil = "__value_nil__"
 
-// cacheImpl cache implementation
+// cacheImpl cache implementation, memory is sharded by key so that goroutines
+// using different keys do not wait for each other
 type cacheImpl struct {
 	options Options
-	tx      *transImpl
+	shards  []*shard
 
-	keys    map[string]string
-	hsets   map[string]map[string]string
-	delKeys map[string]string
+	mu        sync.Mutex          // guards tx, open and leakCheck
+	tx        *transImpl          // current transaction
+	open      map[*transImpl]bool // active transactions checked for leaks
+	leakCheck time.Time           // next time open transactions are checked for leaks
 }
 
 // newCacheImpl create new cacheImpl
@@ -101,10 +206,9 @@ func newCacheImpl(opts ...Option) *cacheImpl {
 	options := newOptions(opts...)
 	c := &cacheImpl{
 		options: options,
-		keys:    make(map[string]string),
-		hsets:   make(map[string]map[string]string),
-		delKeys: make(map[string]string),
+		open:    make(map[*transImpl]bool),
 	}
+	c.shards = newShards(c.options)
 	if c.options.Driver == nil {
 		c.options.Driver = driver.DefaultDriver
 	}
@@ -118,50 +222,207 @@ func (c *cacheImpl) Options() Options {
 
 // Init initialize cache
 func (c *cacheImpl) Init() error {
-	return c.options.Driver.Init()
+	if err := c.options.Driver.Init(); err != nil {
+		return err
+	}
+	if c.options.Journal != nil {
+		return c.recoverJournal()
+	}
+	return nil
 }
 
 // FlushMemory flush data in memory
 func (c *cacheImpl) FlushMemory() {
-	c.keys = make(map[string]string)
-	c.delKeys = make(map[string]string)
-	c.hsets = make(map[string]map[string]string)
+	for _, s := range c.shards {
+		s.Lock()
+		s.reset()
+		s.Unlock()
+	}
 }
 
-// BeginTransaction start a transaction if none active
-func (c *cacheImpl) BeginTransaction() Transaction {
-	if c.tx == nil || c.tx.active == false {
-		c.tx = newTransImpl(c)
+// Invalidate drop keys from memory, used when keys are changed outside of the cache
+func (c *cacheImpl) Invalidate(keys ...string) {
+	for _, k := range keys {
+		s := c.shard(k)
+		s.Lock()
+		s.drop(k)
+		s.gen++
+		s.Unlock()
 	}
-	return c.tx
 }
 
-// getCurrentTransaction get current active transaction
+// touch increase versions of keys changed, only kept for keys watched
+func (c *cacheImpl) touch(keys ...string) {
+	for _, k := range keys {
+		s := c.shard(k)
+		s.Lock()
+		if v, ok := s.versions[k]; ok {
+			v.n++
+		}
+		s.Unlock()
+	}
+}
+
+// BeginTransaction start a transaction, a savepoint nested in the current one if active
+func (c *cacheImpl) BeginTransaction(opts ...TransOption) Transaction {
+	tx := c.lockCurrent()
+	defer tx.release()
+	var nt *transImpl
+	if tx != nil {
+		nt = newSavepoint(tx, opts...)
+	} else {
+		nt = newTransImpl(c, opts...)
+	}
+	c.mu.Lock()
+	c.tx = nt
+	c.mu.Unlock()
+	return nt
+}
+
+// NewTransaction start a transaction independent of the current one
+func (c *cacheImpl) NewTransaction(opts ...TransOption) Transaction {
+	c.checkLeaks()
+	return newTransImpl(c, opts...)
+}
+
+// RunInTransaction run fn in a transaction independent of the current one and commit it,
+// fn is run again up to maxRetries times while the commit fails with ErrConflict
+func (c *cacheImpl) RunInTransaction(fn func(tx Transaction) error, maxRetries int) error {
+	for i := 0; ; i++ {
+		tx := c.NewTransaction()
+		if err := fn(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+		err := tx.Commit()
+		if tx.Active() { // commit aborted by hook
+			tx.Rollback()
+		}
+		if err != ErrConflict || i >= maxRetries {
+			return err
+		}
+	}
+}
+
+// getCurrentTransaction get current active transaction, expired transactions are rolled back
 func (c *cacheImpl) getCurrentTransaction() *transImpl {
-	if c.tx != nil && !c.tx.active {
-		c.tx = nil
+	tx := c.lockCurrent()
+	tx.release()
+	return tx
+}
+
+// lockCurrent get current active transaction locked, nil if none. Commands run by the cache
+// hold the lock while they use the transaction, it is released during driver round-trips.
+func (c *cacheImpl) lockCurrent() *transImpl {
+	c.checkLeaks()
+	for {
+		tx := c.current()
+		if tx == nil {
+			return nil
+		}
+		tx.mu.Lock()
+		if tx.active {
+			if e := tx.expiredScope(); e != nil {
+				e.expire()
+			}
+		}
+		c.mu.Lock()
+		if c.tx == tx && tx.active {
+			c.mu.Unlock()
+			return tx
+		}
+		if c.tx == tx {
+			c.tx = nil
+		}
+		c.mu.Unlock()
+		tx.mu.Unlock() // ended or no longer current, try again
 	}
+}
+
+// current get current transaction, may be inactive
+func (c *cacheImpl) current() *transImpl {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.tx
 }
 
+// checkLeaks report transactions active longer than the leak threshold, once each. Open
+// transactions are checked at most once per half of the threshold.
+func (c *cacheImpl) checkLeaks() {
+	if c.options.OnLeak == nil {
+		return
+	}
+	leaked := []*transImpl{}
+	now := time.Now()
+	c.mu.Lock()
+	if now.Before(c.leakCheck) {
+		c.mu.Unlock()
+		return
+	}
+	c.leakCheck = now.Add(c.options.LeakThreshold / 2)
+	for t := range c.open {
+		if t.Age() > c.options.LeakThreshold {
+			delete(c.open, t)
+			leaked = append(leaked, t)
+		}
+	}
+	c.mu.Unlock()
+	for _, t := range leaked {
+		c.options.OnLeak(t, t.stack)
+	}
+}
+
 // func for keys
 
 // Get value by key
 func (c *cacheImpl) Get(key string) (string, error) {
-	if _, ok := c.delKeys[key]; ok { // already deleted
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.check(); err != nil {
+		return "", err
+	}
+	return c.get(tx, key)
+}
+
+func (c *cacheImpl) get(tx *transImpl, key string) (string, error) {
+	if tx != nil {
+		tx.pinMemory(key)
+		if v, ok := tx.getKey(key); ok { // written by transaction
+			if v == flagValueNil {
+				return "", ErrValueNil
+			}
+			return v, nil
+		}
+		if tx.repeatable() {
+			v, err := tx.pinKey(key)
+			if v == flagValueNil {
+				return "", ErrValueNil
+			}
+			return v, err
+		}
+	}
+	s := c.shard(key)
+	s.Lock()
+	s.access(key)
+	_, deleted := s.delKeys[key]
+	v, ok := s.keys[key]
+	gen := s.gen
+	s.Unlock()
+	if deleted { // already deleted
 		return "", ErrValueNil
 	}
-	if v, ok := c.keys[key]; ok {
+	if ok {
 		if v == flagValueNil { // get before but not found
 			return "", ErrValueNil
 		}
 		return v, nil
 	}
-	v, err := c.options.Driver.Get(key)
+	var err error
+	tx.unlocked(func() { v, err = c.options.Driver.Get(key) })
 	if err == nil {
-		c.keys[key] = v
+		s.fill(key, gen, func() { s.keys[key] = v })
 	} else if err == driver.ErrValueNil { // not found, set nil value flag
-		c.keys[key] = flagValueNil
+		s.fill(key, gen, func() { s.keys[key] = flagValueNil })
 	}
 	return v, err
 }
@@ -183,30 +444,77 @@ func ValueToString(value interface{}) string {
 
 // Set key-value pair
 func (c *cacheImpl) Set(key string, value interface{}) error {
-	tx := c.getCurrentTransaction()
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.set(tx, key, value)
+}
+
+func (c *cacheImpl) set(tx *transImpl, key string, value interface{}) error {
 	if tx != nil {
 		tx.onSet(key, value)
-		delete(c.delKeys, key)
-		c.keys[key] = ValueToString(value)
+		tx.setKey(key, ValueToString(value))
 		return nil
 	}
+	s := c.shard(key)
+	gen := c.generation(key)
 	err := c.options.Driver.Set(key, value)
 	if err == nil {
-		c.keys[key] = ValueToString(value)
+		s.write(key, gen, func() { s.keys[key] = ValueToString(value) })
+		c.touch(key)
 	}
 	return err
 }
 
 // MGet get multiple keys
 func (c *cacheImpl) MGet(keys []string) (map[string]string, error) {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.check(); err != nil {
+		return nil, err
+	}
+	return c.mGet(tx, keys)
+}
+
+func (c *cacheImpl) mGet(tx *transImpl, keys []string) (map[string]string, error) {
 	hits := map[string]string{}
 	noh := []string{}
 	for _, k := range keys {
-		if _, ok := c.delKeys[k]; ok {
+		if tx != nil {
+			tx.pinMemory(k)
+			if v, ok := tx.getKey(k); ok {
+				if v == flagValueNil {
+					hits[k] = ""
+				} else {
+					hits[k] = v
+				}
+				continue
+			}
+			if tx.repeatable() {
+				v, err := tx.pinKey(k)
+				if err != nil {
+					return nil, err
+				}
+				if v == flagValueNil {
+					v = ""
+				}
+				hits[k] = v
+				continue
+			}
+		}
+		s := c.shard(k)
+		s.Lock()
+		s.access(k)
+		_, deleted := s.delKeys[k]
+		v, ok := s.keys[k]
+		s.Unlock()
+		if deleted {
 			hits[k] = ""
 			continue
 		}
-		if v, ok := c.keys[k]; ok {
+		if ok {
 			if v == flagValueNil {
 				hits[k] = ""
 			} else {
@@ -217,7 +525,9 @@ func (c *cacheImpl) MGet(keys []string) (map[string]string, error) {
 		noh = append(noh, k)
 	}
 	if len(noh) > 0 {
-		nm, err := c.options.Driver.MGet(noh)
+		var nm map[string]string
+		var err error
+		tx.unlocked(func() { nm, err = c.options.Driver.MGet(noh) })
 		if err != nil {
 			return nil, err
 		}
@@ -230,20 +540,35 @@ func (c *cacheImpl) MGet(keys []string) (map[string]string, error) {
 
 // MSet set multiple key-value pairs
 func (c *cacheImpl) MSet(kvs map[string]interface{}) error {
-	tx := c.getCurrentTransaction()
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.mSet(tx, kvs)
+}
+
+func (c *cacheImpl) mSet(tx *transImpl, kvs map[string]interface{}) error {
 	if tx != nil {
 		tx.onMSet(kvs)
 		for k, v := range kvs {
-			delete(c.delKeys, k)
-			c.keys[k] = ValueToString(v)
+			tx.setKey(k, ValueToString(v))
 		}
 		return nil
 	}
+	gens := map[string]uint64{}
+	for k := range kvs {
+		gens[k] = c.generation(k)
+	}
 	err := c.options.Driver.MSet(kvs)
 	if err == nil {
 		for k, v := range kvs {
-			delete(c.delKeys, k)
-			c.keys[k] = ValueToString(v)
+			s, k, v := c.shard(k), k, v
+			s.write(k, gens[k], func() {
+				delete(s.delKeys, k)
+				s.keys[k] = ValueToString(v)
+			})
+			c.touch(k)
 		}
 	}
 	return err
@@ -251,103 +576,377 @@ func (c *cacheImpl) MSet(kvs map[string]interface{}) error {
 
 // Del delete specified key
 func (c *cacheImpl) Del(key string) error {
-	tx := c.getCurrentTransaction()
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.del(tx, key)
+}
+
+func (c *cacheImpl) del(tx *transImpl, key string) error {
 	if tx != nil {
 		tx.onDel(key)
-		delete(c.keys, key)
-		c.delKeys[key] = ""
+		tx.delKey(key)
 		return nil
 	}
+	s := c.shard(key)
+	gen := c.generation(key)
 	err := c.options.Driver.Del(key)
 	if err == nil {
-		delete(c.keys, key)
-		c.delKeys[key] = ""
+		s.write(key, gen, func() {
+			delete(s.keys, key)
+			delete(s.hexpires, key)
+			s.delKeys[key] = ""
+		})
+		c.touch(key)
 	}
 	return err
 }
 
 // Check if the given key exists
 func (c *cacheImpl) Exists(key string) (bool, error) {
-	if _, ok := c.delKeys[key]; ok { // already deleted
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.check(); err != nil {
+		return false, err
+	}
+	return c.exists(tx, key)
+}
+
+func (c *cacheImpl) exists(tx *transImpl, key string) (bool, error) {
+	if tx != nil {
+		tx.pinMemory(key)
+		v, ok := tx.getKey(key)
+		if (ok && v != flagValueNil) || tx.hasHashKeys(key) {
+			return true, nil
+		}
+		if ok {
+			return false, nil
+		}
+	}
+	s := c.shard(key)
+	s.Lock()
+	s.access(key)
+	_, deleted := s.delKeys[key]
+	v, ok := s.keys[key]
+	_, hok := s.hsets[key]
+	s.Unlock()
+	if deleted { // already deleted
 		return false, nil
 	}
-	if v, ok := c.keys[key]; ok { // already loaded into memory
+	if ok { // already loaded into memory
 		if v != flagValueNil {
 			return true, nil
 		}
 		return false, nil
 	}
-	if _, ok := c.hsets[key]; ok { // already loaded into memory
+	if hok { // already loaded into memory
 		return true, nil
 	}
-	return c.options.Driver.Exists(key)
+	var exists bool
+	var err error
+	tx.unlocked(func() { exists, err = c.options.Driver.Exists(key) })
+	return exists, err
 }
 
-// Expire set key expiration
+// Expire set key expiration in seconds
 func (c *cacheImpl) Expire(key string, ex int64) error {
-	tx := c.getCurrentTransaction()
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.expire(tx, key, ex)
+}
+
+func (c *cacheImpl) expire(tx *transImpl, key string, ex int64) error {
 	if tx != nil {
 		tx.onExpire(key, ex)
 		return nil
 	}
-	return c.options.Driver.Expire(key, ex)
+	err := c.options.Driver.Expire(key, ex)
+	if err == nil {
+		c.touch(key)
+	}
+	return err
+}
+
+// PExpire set key expiration in milliseconds
+func (c *cacheImpl) PExpire(key string, ms int64) error {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.pExpire(tx, key, ms)
+}
+
+func (c *cacheImpl) pExpire(tx *transImpl, key string, ms int64) error {
+	if tx != nil {
+		tx.onPExpire(key, ms)
+		return nil
+	}
+	err := c.options.Driver.PExpire(key, ms)
+	if err == nil {
+		c.touch(key)
+	}
+	return err
+}
+
+// ExpireDuration set key expiration after duration d
+func (c *cacheImpl) ExpireDuration(key string, d time.Duration) error {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.expireDuration(tx, key, d)
+}
+
+func (c *cacheImpl) expireDuration(tx *transImpl, key string, d time.Duration) error {
+	if tx != nil {
+		tx.onExpireDuration(key, d)
+		return nil
+	}
+	err := c.options.Driver.ExpireDuration(key, d)
+	if err == nil {
+		c.touch(key)
+	}
+	return err
+}
+
+// ExpireAt set key expiration at time tm
+func (c *cacheImpl) ExpireAt(key string, tm time.Time) error {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.expireAt(tx, key, tm)
+}
+
+func (c *cacheImpl) expireAt(tx *transImpl, key string, tm time.Time) error {
+	if tx != nil {
+		tx.onExpireAt(key, tm)
+		return nil
+	}
+	err := c.options.Driver.ExpireAt(key, tm)
+	if err == nil {
+		c.touch(key)
+	}
+	return err
+}
+
+// TTL get remaining time to live of key, -1 means no expiration, ErrValueNil if key not exist
+func (c *cacheImpl) TTL(key string) (time.Duration, error) {
+	ttl, err := c.options.Driver.TTL(key)
+	if err == driver.ErrValueNil {
+		err = ErrValueNil
+	}
+	return ttl, err
 }
 
 // Incr increment key
 func (c *cacheImpl) Incr(key string, delta interface{}) (string, error) {
-	nv, err := c.options.Driver.Incr(key, delta)
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return "", err
+	}
+	return c.incr(tx, key, delta)
+}
+
+func (c *cacheImpl) incr(tx *transImpl, key string, delta interface{}) (string, error) {
+	if tx != nil && c.options.DeferredCounters {
+		v, err := c.get(tx, key)
+		if err == ErrValueNil { // counts as zero
+			v, err = "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		nv, err := addDelta(v, delta, 1)
+		if err != nil {
+			return "", err
+		}
+		if err := tx.checkWrite(); err != nil { // ended while reading
+			return "", err
+		}
+		tx.onIncr(key, delta, true)
+		tx.setKey(key, nv)
+		return nv, nil
+	}
+	s := c.shard(key)
+	gen := c.generation(key)
+	var nv string
+	var err error
+	tx.unlocked(func() { nv, err = c.options.Driver.Incr(key, delta) })
 	if err != nil {
 		return "", err
 	}
-	delete(c.delKeys, key)
-	c.keys[key] = nv
-	tx := c.getCurrentTransaction()
+	c.touch(key)
 	if tx != nil {
-		tx.onIncr(key, delta)
+		if err := tx.checkWrite(); err != nil { // ended meanwhile, it does not revert the counter
+			c.options.Driver.Decr(key, delta)
+			return "", err
+		}
+		tx.onIncr(key, delta, false)
+		tx.setKey(key, nv)
+		return nv, nil
 	}
-	return nv, err
+	s.write(key, gen, func() {
+		delete(s.delKeys, key)
+		s.keys[key] = nv
+	})
+	return nv, nil
 }
 
 // Decr increment key
 func (c *cacheImpl) Decr(key string, delta interface{}) (string, error) {
-	nv, err := c.options.Driver.Decr(key, delta)
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return "", err
+	}
+	return c.decr(tx, key, delta)
+}
+
+func (c *cacheImpl) decr(tx *transImpl, key string, delta interface{}) (string, error) {
+	if tx != nil && c.options.DeferredCounters {
+		v, err := c.get(tx, key)
+		if err == ErrValueNil { // counts as zero
+			v, err = "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		nv, err := addDelta(v, delta, -1)
+		if err != nil {
+			return "", err
+		}
+		if err := tx.checkWrite(); err != nil { // ended while reading
+			return "", err
+		}
+		tx.onDecr(key, delta, true)
+		tx.setKey(key, nv)
+		return nv, nil
+	}
+	s := c.shard(key)
+	gen := c.generation(key)
+	var nv string
+	var err error
+	tx.unlocked(func() { nv, err = c.options.Driver.Decr(key, delta) })
 	if err != nil {
 		return "", err
 	}
-	delete(c.delKeys, key)
-	c.keys[key] = nv
-	tx := c.getCurrentTransaction()
+	c.touch(key)
 	if tx != nil {
-		tx.onDecr(key, delta)
+		if err := tx.checkWrite(); err != nil { // ended meanwhile, it does not revert the counter
+			c.options.Driver.Incr(key, delta)
+			return "", err
+		}
+		tx.onDecr(key, delta, false)
+		tx.setKey(key, nv)
+		return nv, nil
 	}
-	return nv, err
+	s.write(key, gen, func() {
+		delete(s.delKeys, key)
+		s.keys[key] = nv
+	})
+	return nv, nil
 }
 
-// func for hashes
-
-func (c *cacheImpl) setMemoryHashSet(key string, hk string, val string) {
-	m, ok := c.hsets[key]
-	if !ok {
-		m = map[string]string{}
+// addDelta add delta multiplied by sign to counter value v, empty value counts as zero
+func addDelta(v string, delta interface{}, sign int64) (string, error) {
+	if v == "" {
+		v = "0"
+	}
+	var n int64
+	var f float64
+	switch d := delta.(type) {
+	case int:
+		n = int64(d)
+	case int32:
+		n = int64(d)
+	case int64:
+		n = d
+	case float32:
+		f = float64(d)
+	case float64:
+		f = d
+	default:
+		return "", fmt.Errorf("cache: invalid delta value (%v)", delta)
+	}
+	switch delta.(type) {
+	case float32, float64:
+		fv, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", fmt.Errorf("cache: value is not a float (%s)", v)
+		}
+		return strconv.FormatFloat(fv+float64(sign)*f, 'f', -1, 64), nil
+	}
+	nv, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("cache: value is not an integer (%s)", v)
 	}
-	m[hk] = val
-	c.hsets[key] = m
+	return fmt.Sprintf("%d", nv+sign*n), nil
 }
 
+// func for hashes
+
 // HGEt get hash key
 func (c *cacheImpl) HGet(key string, hk string) (string, error) {
-	if _, ok := c.delKeys[key]; ok { // key is deleted
-		return "", ErrValueNil
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.check(); err != nil {
+		return "", err
 	}
-	if m, ok := c.hsets[key]; ok { // hash set is loaded into memory
-		if v, o := m[hk]; o {
+	return c.hGet(tx, key, hk)
+}
+
+func (c *cacheImpl) hGet(tx *transImpl, key string, hk string) (string, error) {
+	if tx != nil {
+		tx.pinMemory(key)
+		if v, ok := tx.getHashKey(key, hk); ok { // written by transaction
 			if v == flagValueNil {
 				return "", ErrValueNil
 			}
 			return v, nil
 		}
+		if tx.repeatable() {
+			v, err := tx.pinHashKey(key, hk)
+			if v == flagValueNil {
+				return "", ErrValueNil
+			}
+			return v, err
+		}
+	}
+	s := c.shard(key)
+	s.Lock()
+	s.access(key)
+	_, deleted := s.delKeys[key]
+	s.expireHash(key)
+	v, ok := s.hsets[key][hk]
+	gen := s.gen
+	s.Unlock()
+	if deleted { // key is deleted
+		return "", ErrValueNil
+	}
+	if ok { // hash set is loaded into memory
+		if v == flagValueNil {
+			return "", ErrValueNil
+		}
+		return v, nil
 	}
-	v, err := c.options.Driver.HGet(key, hk)
+	var err error
+	deadlines := map[string]time.Time{}
+	known := true
+	tx.unlocked(func() {
+		if v, err = c.options.Driver.HGet(key, hk); err == nil {
+			deadlines, known = c.hashDeadlines(key, []string{hk})
+		}
+	})
 	if err != nil && err != driver.ErrValueNil {
 		return "", err
 	}
@@ -355,36 +954,117 @@ func (c *cacheImpl) HGet(key string, hk string) (string, error) {
 		v = flagValueNil
 		err = ErrValueNil
 	}
-	delete(c.delKeys, key)
-	c.setMemoryHashSet(key, hk, v)
+	if !known {
+		return v, err
+	}
+	s.fill(key, gen, func() {
+		delete(s.delKeys, key)
+		s.setHashKey(key, hk, v)
+		s.setHashExpire(key, []string{hk}, deadlines[hk])
+	})
 	return v, err
 }
 
+// hashDeadlines get expiration deadlines of hash keys read from driver, so that they are not
+// served from memory once expired. ok is false if unknown, the hash keys are not kept then.
+func (c *cacheImpl) hashDeadlines(key string, hks []string) (deadlines map[string]time.Time, ok bool) {
+	ttls, err := c.options.Driver.HTTL(key, hks...)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	deadlines = map[string]time.Time{}
+	for hk, d := range ttls {
+		if d > 0 {
+			deadlines[hk] = now.Add(d)
+		}
+	}
+	return deadlines, true
+}
+
 // HSet set hash key
 func (c *cacheImpl) HSet(key string, hk string, value interface{}) error {
-	tx := c.getCurrentTransaction()
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.hSet(tx, key, hk, value)
+}
+
+func (c *cacheImpl) hSet(tx *transImpl, key string, hk string, value interface{}) error {
 	if tx != nil {
 		tx.onHSet(key, hk, value)
-		delete(c.delKeys, key)
-		c.setMemoryHashSet(key, hk, ValueToString(value))
+		tx.setHashKey(key, hk, ValueToString(value))
+		tx.setHashExpire(key, []string{hk}, time.Time{})
 		return nil
 	}
+	s := c.shard(key)
+	gen := c.generation(key)
 	err := c.options.Driver.HSet(key, hk, value)
 	if err == nil {
-		delete(c.delKeys, key)
-		c.setMemoryHashSet(key, hk, ValueToString(value))
+		s.write(key, gen, func() {
+			delete(s.delKeys, key)
+			s.setHashKey(key, hk, ValueToString(value))
+			s.setHashExpire(key, []string{hk}, time.Time{})
+		})
+		c.touch(key)
 	}
 	return err
 }
 
 // HMGet get multiple hash keys
 func (c *cacheImpl) HMGet(key string, hks []string) (map[string]string, error) {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.check(); err != nil {
+		return nil, err
+	}
+	return c.hMGet(tx, key, hks)
+}
+
+func (c *cacheImpl) hMGet(tx *transImpl, key string, hks []string) (map[string]string, error) {
 	hits := map[string]string{}
-	if _, ok := c.delKeys[key]; ok { // already deleted whole key
+	if tx != nil {
+		tx.pinMemory(key)
+		rest := []string{}
+		for _, hk := range hks {
+			if v, ok := tx.getHashKey(key, hk); !ok {
+				rest = append(rest, hk)
+			} else if v == flagValueNil {
+				hits[hk] = ""
+			} else {
+				hits[hk] = v
+			}
+		}
+		hks = rest
+		if tx.repeatable() {
+			for _, hk := range hks {
+				v, err := tx.pinHashKey(key, hk)
+				if err != nil {
+					return nil, err
+				}
+				if v == flagValueNil {
+					v = ""
+				}
+				hits[hk] = v
+			}
+			return hits, nil
+		}
+		if len(hks) == 0 {
+			return hits, nil
+		}
+	}
+	s := c.shard(key)
+	s.Lock()
+	s.access(key)
+	if _, ok := s.delKeys[key]; ok { // already deleted whole key
+		s.Unlock()
 		return hits, nil
 	}
+	s.expireHash(key)
 	noh := []string{}
-	if m, ok := c.hsets[key]; ok {
+	if m, ok := s.hsets[key]; ok {
 		for _, hk := range hks {
 			if v, o := m[hk]; o {
 				if v == flagValueNil {
@@ -399,15 +1079,32 @@ func (c *cacheImpl) HMGet(key string, hks []string) (map[string]string, error) {
 	} else {
 		noh = hks
 	}
+	gen := s.gen
+	s.Unlock()
 
 	if len(noh) > 0 {
-		nm, err := c.options.Driver.HMGet(key, noh)
+		var nm map[string]string
+		var deadlines map[string]time.Time
+		var err error
+		known := false
+		tx.unlocked(func() {
+			if nm, err = c.options.Driver.HMGet(key, noh); err == nil {
+				deadlines, known = c.hashDeadlines(key, noh)
+			}
+		})
 		if err != nil {
 			return nil, err
 		}
 		for k, v := range nm {
 			hits[k] = v
-			c.setMemoryHashSet(key, k, v)
+		}
+		if known {
+			s.fill(key, gen, func() {
+				for k, v := range nm {
+					s.setHashKey(key, k, v)
+					s.setHashExpire(key, []string{k}, deadlines[k])
+				}
+			})
 		}
 	}
 
@@ -416,42 +1113,106 @@ func (c *cacheImpl) HMGet(key string, hks []string) (map[string]string, error) {
 
 // HMSet set multiple hash keys
 func (c *cacheImpl) HMSet(key string, kvs map[string]interface{}) error {
-	tx := c.getCurrentTransaction()
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.hMSet(tx, key, kvs)
+}
+
+func (c *cacheImpl) hMSet(tx *transImpl, key string, kvs map[string]interface{}) error {
 	if tx != nil {
 		tx.onHMSet(key, kvs)
-		delete(c.delKeys, key)
 		for k, v := range kvs {
-			c.setMemoryHashSet(key, k, ValueToString(v))
+			tx.setHashKey(key, k, ValueToString(v))
+			tx.setHashExpire(key, []string{k}, time.Time{})
 		}
 		return nil
 	}
 
+	s := c.shard(key)
+	gen := c.generation(key)
 	err := c.options.Driver.HMSet(key, kvs)
 	if err == nil {
-		delete(c.delKeys, key)
-		for k, v := range kvs {
-			c.setMemoryHashSet(key, k, ValueToString(v))
-		}
+		s.write(key, gen, func() {
+			delete(s.delKeys, key)
+			for k, v := range kvs {
+				s.setHashKey(key, k, ValueToString(v))
+				s.setHashExpire(key, []string{k}, time.Time{})
+			}
+		})
+		c.touch(key)
 	}
 	return err
 }
 
 // HGetAll get all hash keys
 func (c *cacheImpl) HGetAll(key string) (map[string]string, error) {
-	if _, ok := c.delKeys[key]; ok {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.check(); err != nil {
+		return nil, err
+	}
+	return c.hGetAll(tx, key)
+}
+
+func (c *cacheImpl) hGetAll(tx *transImpl, key string) (map[string]string, error) {
+	if tx != nil {
+		tx.pinMemory(key)
+		ret := map[string]string{}
+		if !tx.deleted(key) {
+			var m map[string]string
+			var err error
+			if tx.repeatable() {
+				m, err = tx.pinHash(key)
+			} else {
+				tx.unlocked(func() { m, err = c.hgetAllCommitted(key) })
+			}
+			if err != nil {
+				return nil, err
+			}
+			ret = m
+		}
+		for _, hk := range tx.hashKeys(key) {
+			ret[hk] = ""
+		}
+		for hk := range ret { // apply writes of transaction
+			if v, ok := tx.getHashKey(key, hk); ok {
+				if v == flagValueNil {
+					ret[hk] = ""
+				} else {
+					ret[hk] = v
+				}
+			}
+		}
+		return ret, nil
+	}
+	return c.hgetAllCommitted(key)
+}
+
+// hgetAllCommitted get all hash keys from memory and driver
+func (c *cacheImpl) hgetAllCommitted(key string) (map[string]string, error) {
+	s := c.shard(key)
+	s.Lock()
+	s.access(key)
+	_, deleted := s.delKeys[key]
+	s.Unlock()
+	if deleted {
 		return map[string]string{}, nil
 	}
 	ret, err := c.options.Driver.HGetAll(key)
 	if err != nil {
 		return nil, err
 	}
-	if m, ok := c.hsets[key]; ok {
-		for k, v := range m {
-			if v == flagValueNil {
-				ret[k] = ""
-			} else {
-				ret[k] = v
-			}
+	s.Lock()
+	defer s.Unlock()
+	s.expireHash(key)
+	for k, v := range s.hsets[key] {
+		if v == flagValueNil {
+			ret[k] = ""
+		} else {
+			ret[k] = v
 		}
 	}
 	return ret, err
@@ -459,62 +1220,377 @@ func (c *cacheImpl) HGetAll(key string) (map[string]string, error) {
 
 // HDel delete hash key
 func (c *cacheImpl) HDel(key string, hk string) error {
-	tx := c.getCurrentTransaction()
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.hDel(tx, key, hk)
+}
+
+func (c *cacheImpl) hDel(tx *transImpl, key string, hk string) error {
 	if tx != nil {
 		tx.onHDel(key, hk)
-		c.setMemoryHashSet(key, hk, flagValueNil)
+		tx.setHashKey(key, hk, flagValueNil)
+		tx.setHashExpire(key, []string{hk}, time.Time{})
 		return nil
 	}
 
+	s := c.shard(key)
+	gen := c.generation(key)
 	err := c.options.Driver.HDel(key, hk)
 	if err == nil {
-		c.setMemoryHashSet(key, hk, flagValueNil)
+		s.write(key, gen, func() {
+			s.setHashKey(key, hk, flagValueNil)
+			s.setHashExpire(key, []string{hk}, time.Time{})
+		})
+		c.touch(key)
 	}
 	return err
 }
 
 // HExists check if the given hash key exists
 func (c *cacheImpl) HExists(key string, hk string) (bool, error) {
-	if _, ok := c.delKeys[key]; ok {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.check(); err != nil {
+		return false, err
+	}
+	return c.hExists(tx, key, hk)
+}
+
+func (c *cacheImpl) hExists(tx *transImpl, key string, hk string) (bool, error) {
+	if tx != nil {
+		tx.pinMemory(key)
+		if v, ok := tx.getHashKey(key, hk); ok {
+			return v != flagValueNil, nil
+		}
+		if tx.repeatable() {
+			v, err := tx.pinHashKey(key, hk)
+			return err == nil && v != flagValueNil, err
+		}
+	}
+	s := c.shard(key)
+	s.Lock()
+	s.access(key)
+	_, deleted := s.delKeys[key]
+	s.expireHash(key)
+	v, ok := s.hsets[key][hk]
+	s.Unlock()
+	if deleted {
 		return false, nil
 	}
-	if m, ok := c.hsets[key]; ok {
-		if v, o := m[hk]; o {
-			if v == flagValueNil {
-				return false, nil
-			}
-			return true, nil
+	if ok {
+		if v == flagValueNil {
+			return false, nil
 		}
+		return true, nil
 	}
-	return c.options.Driver.HExists(key, hk)
+	var exists bool
+	var err error
+	tx.unlocked(func() { exists, err = c.options.Driver.HExists(key, hk) })
+	return exists, err
 }
 
 // HIncr increment value of hash key
 func (c *cacheImpl) HIncr(key string, hk string, delta interface{}) (string, error) {
-	nv, err := c.options.Driver.HIncr(key, hk, delta)
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return "", err
+	}
+	return c.hIncr(tx, key, hk, delta)
+}
+
+func (c *cacheImpl) hIncr(tx *transImpl, key string, hk string, delta interface{}) (string, error) {
+	if tx != nil && c.options.DeferredCounters {
+		v, err := c.hGet(tx, key, hk)
+		if err == ErrValueNil { // counts as zero
+			v, err = "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		nv, err := addDelta(v, delta, 1)
+		if err != nil {
+			return "", err
+		}
+		if err := tx.checkWrite(); err != nil { // ended while reading
+			return "", err
+		}
+		tx.onHIncr(key, hk, delta, true)
+		tx.setHashKey(key, hk, nv)
+		return nv, nil
+	}
+	s := c.shard(key)
+	gen := c.generation(key)
+	var nv string
+	var err error
+	tx.unlocked(func() { nv, err = c.options.Driver.HIncr(key, hk, delta) })
 	if err != nil {
 		return "", err
 	}
-	delete(c.delKeys, key)
-	c.setMemoryHashSet(key, hk, nv)
-	tx := c.getCurrentTransaction()
+	c.touch(key)
 	if tx != nil {
-		tx.onHIncr(key, hk, delta)
+		if err := tx.checkWrite(); err != nil { // ended meanwhile, it does not revert the counter
+			c.options.Driver.HDecr(key, hk, delta)
+			return "", err
+		}
+		tx.onHIncr(key, hk, delta, false)
+		tx.setHashKey(key, hk, nv)
+		return nv, nil
 	}
-	return nv, err
+	s.write(key, gen, func() {
+		delete(s.delKeys, key)
+		s.setHashKey(key, hk, nv)
+	})
+	return nv, nil
 }
 
 // HDecr decrement value of hash key
 func (c *cacheImpl) HDecr(key string, hk string, delta interface{}) (string, error) {
-	nv, err := c.options.Driver.HDecr(key, hk, delta)
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return "", err
+	}
+	return c.hDecr(tx, key, hk, delta)
+}
+
+func (c *cacheImpl) hDecr(tx *transImpl, key string, hk string, delta interface{}) (string, error) {
+	if tx != nil && c.options.DeferredCounters {
+		v, err := c.hGet(tx, key, hk)
+		if err == ErrValueNil { // counts as zero
+			v, err = "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		nv, err := addDelta(v, delta, -1)
+		if err != nil {
+			return "", err
+		}
+		if err := tx.checkWrite(); err != nil { // ended while reading
+			return "", err
+		}
+		tx.onHDecr(key, hk, delta, true)
+		tx.setHashKey(key, hk, nv)
+		return nv, nil
+	}
+	s := c.shard(key)
+	gen := c.generation(key)
+	var nv string
+	var err error
+	tx.unlocked(func() { nv, err = c.options.Driver.HDecr(key, hk, delta) })
 	if err != nil {
 		return "", err
 	}
-	delete(c.delKeys, key)
-	c.setMemoryHashSet(key, hk, nv)
-	tx := c.getCurrentTransaction()
+	c.touch(key)
+	if tx != nil {
+		if err := tx.checkWrite(); err != nil { // ended meanwhile, it does not revert the counter
+			c.options.Driver.HIncr(key, hk, delta)
+			return "", err
+		}
+		tx.onHDecr(key, hk, delta, false)
+		tx.setHashKey(key, hk, nv)
+		return nv, nil
+	}
+	s.write(key, gen, func() {
+		delete(s.delKeys, key)
+		s.setHashKey(key, hk, nv)
+	})
+	return nv, nil
+}
+
+// HExpire set expiration of hash keys in seconds
+func (c *cacheImpl) HExpire(key string, ex int64, hks ...string) error {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.hExpire(tx, key, ex, hks...)
+}
+
+func (c *cacheImpl) hExpire(tx *transImpl, key string, ex int64, hks ...string) error {
+	deadline := time.Now().Add(time.Duration(ex) * time.Second)
+	if tx != nil {
+		tx.onHExpire(key, ex, hks)
+		tx.setHashExpire(key, hks, deadline)
+		return nil
+	}
+	s := c.shard(key)
+	gen := c.generation(key)
+	err := c.options.Driver.HExpire(key, ex, hks...)
+	if err == nil {
+		s.write(key, gen, func() { s.setHashExpire(key, hks, deadline) })
+		c.touch(key)
+	}
+	return err
+}
+
+// HPExpire set expiration of hash keys in milliseconds
+func (c *cacheImpl) HPExpire(key string, ms int64, hks ...string) error {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
+	}
+	return c.hPExpire(tx, key, ms, hks...)
+}
+
+func (c *cacheImpl) hPExpire(tx *transImpl, key string, ms int64, hks ...string) error {
+	deadline := time.Now().Add(time.Duration(ms) * time.Millisecond)
 	if tx != nil {
-		tx.onHDecr(key, hk, delta)
+		tx.onHPExpire(key, ms, hks)
+		tx.setHashExpire(key, hks, deadline)
+		return nil
+	}
+	s := c.shard(key)
+	gen := c.generation(key)
+	err := c.options.Driver.HPExpire(key, ms, hks...)
+	if err == nil {
+		s.write(key, gen, func() { s.setHashExpire(key, hks, deadline) })
+		c.touch(key)
+	}
+	return err
+}
+
+// HTTL get remaining time to live of hash keys, -1 means no expiration, missing hash keys are omitted
+func (c *cacheImpl) HTTL(key string, hks ...string) (map[string]time.Duration, error) {
+	return c.options.Driver.HTTL(key, hks...)
+}
+
+// HPersist remove expiration of hash keys
+func (c *cacheImpl) HPersist(key string, hks ...string) error {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return err
 	}
-	return nv, err
+	return c.hPersist(tx, key, hks...)
+}
+
+func (c *cacheImpl) hPersist(tx *transImpl, key string, hks ...string) error {
+	if tx != nil {
+		tx.onHPersist(key, hks)
+		tx.setHashExpire(key, hks, time.Time{})
+		return nil
+	}
+	s := c.shard(key)
+	gen := c.generation(key)
+	err := c.options.Driver.HPersist(key, hks...)
+	if err == nil {
+		s.write(key, gen, func() { s.setHashExpire(key, hks, time.Time{}) })
+		c.touch(key)
+	}
+	return err
+}
+
+// func for streams
+
+// XAdd append message to stream, use "*" as id to let server generate it.
+// Inside a transaction the message is published on commit and the generated id is empty.
+func (c *cacheImpl) XAdd(key string, id string, values map[string]interface{}) (string, error) {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return "", err
+	}
+	return c.xAdd(tx, key, id, values)
+}
+
+func (c *cacheImpl) xAdd(tx *transImpl, key string, id string, values map[string]interface{}) (string, error) {
+	if tx != nil {
+		tx.onXAdd(key, id, values)
+		if id == "*" {
+			return "", nil
+		}
+		return id, nil
+	}
+	return c.options.Driver.XAdd(key, id, values)
+}
+
+// XRange get messages with id between start and end, count <= 0 means no limit
+func (c *cacheImpl) XRange(key string, start string, end string, count int64) ([]driver.StreamMessage, error) {
+	return c.options.Driver.XRange(key, start, end, count)
+}
+
+// XRead read messages after the given ids (stream key => id), block > 0 waits up to block for new messages
+func (c *cacheImpl) XRead(streams map[string]string, count int64, block time.Duration) ([]driver.Stream, error) {
+	return c.options.Driver.XRead(streams, count, block)
+}
+
+// XGroupCreate create consumer group starting at id start, mkstream creates the stream if not exist
+func (c *cacheImpl) XGroupCreate(key string, group string, start string, mkstream bool) error {
+	return c.options.Driver.XGroupCreate(key, group, start, mkstream)
+}
+
+// XReadGroup read messages as consumer of group, use ">" as id to get messages never delivered
+func (c *cacheImpl) XReadGroup(group string, consumer string, streams map[string]string, count int64, block time.Duration) ([]driver.Stream, error) {
+	return c.options.Driver.XReadGroup(group, consumer, streams, count, block)
+}
+
+// XAck acknowledge messages of group
+func (c *cacheImpl) XAck(key string, group string, ids ...string) (int64, error) {
+	return c.options.Driver.XAck(key, group, ids...)
+}
+
+// XPending get pending messages of group, empty consumer means all consumers
+func (c *cacheImpl) XPending(key string, group string, start string, end string, count int64, consumer string) ([]driver.PendingMessage, error) {
+	return c.options.Driver.XPending(key, group, start, end, count, consumer)
+}
+
+// XClaim take ownership of pending messages idle for at least minIdle
+func (c *cacheImpl) XClaim(key string, group string, consumer string, minIdle time.Duration, ids ...string) ([]driver.StreamMessage, error) {
+	return c.options.Driver.XClaim(key, group, consumer, minIdle, ids...)
+}
+
+// func for pub/sub
+
+// Publish post message to channel, returns the number of clients received it.
+// Inside a transaction the message is published on commit and 0 is returned.
+func (c *cacheImpl) Publish(channel string, msg interface{}) (int64, error) {
+	tx := c.lockCurrent()
+	defer tx.release()
+	if err := tx.checkWrite(); err != nil {
+		return 0, err
+	}
+	return c.publish(tx, channel, msg)
+}
+
+func (c *cacheImpl) publish(tx *transImpl, channel string, msg interface{}) (int64, error) {
+	if tx != nil {
+		tx.onPublish(channel, msg)
+		return 0, nil
+	}
+	return c.options.Driver.Publish(channel, msg)
+}
+
+// Subscribe subscribe channels
+func (c *cacheImpl) Subscribe(channels ...string) (driver.Subscription, error) {
+	return c.options.Driver.Subscribe(channels...)
+}
+
+// PSubscribe subscribe channels matching patterns
+func (c *cacheImpl) PSubscribe(patterns ...string) (driver.Subscription, error) {
+	return c.options.Driver.PSubscribe(patterns...)
+}
+
+// func for scripts
+
+// Eval run script, keys passed to the script are dropped from memory
+func (c *cacheImpl) Eval(script *driver.Script, keysAndArgs ...interface{}) (interface{}, error) {
+	keys := script.Keys(keysAndArgs...)
+	defer c.touch(keys...)
+	defer c.Invalidate(keys...)
+	return c.options.Driver.Eval(script, keysAndArgs...)
+}
+
+// EvalSha run script loaded before by its SHA1 hash, keys passed to the script are dropped from memory
+func (c *cacheImpl) EvalSha(sha string, keyCount int, keysAndArgs ...interface{}) (interface{}, error) {
+	keys := driver.ScriptKeys(keyCount, keysAndArgs...)
+	defer c.touch(keys...)
+	defer c.Invalidate(keys...)
+	return c.options.Driver.EvalSha(sha, keyCount, keysAndArgs...)
 }
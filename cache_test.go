@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-lego/cache/driver"
 	dmock "github.com/go-lego/cache/driver/mock"
 	"github.com/golang/mock/gomock"
 )
@@ -17,8 +21,8 @@ func TestGet(t *testing.T) {
 	d.EXPECT().Get("test").Return("test", nil)
 	d.EXPECT().Get("testno").Return("", errors.New("test"))
 
-	if len(c.keys) > 0 {
-		t.Error("Initial memory was expected to be empty, but: ", c.keys)
+	if len(memory(c).keys) > 0 {
+		t.Error("Initial memory was expected to be empty, but: ", memory(c).keys)
 	}
 	v, err := c.Get("test")
 	if err != nil {
@@ -28,7 +32,7 @@ func TestGet(t *testing.T) {
 		t.Error("Get key 'test' was expected to value 'test', but: ", v)
 	}
 
-	v, ok := c.keys["test"]
+	v, ok := c.shard("test").keys["test"]
 	if !ok || v != "test" {
 		t.Error("Memory incorrect after get: ", v, ok)
 	}
@@ -55,14 +59,14 @@ func TestSet(t *testing.T) {
 
 	d.EXPECT().Set("test", "test").Return(nil)
 
-	if len(c.keys) > 0 {
-		t.Error("Initial memory was expected to be empty, but: ", c.keys)
+	if len(memory(c).keys) > 0 {
+		t.Error("Initial memory was expected to be empty, but: ", memory(c).keys)
 	}
 	err := c.Set("test", "test")
 	if err != nil {
 		t.Error("No error was expected for set, but: ", err)
 	}
-	v, ok := c.keys["test"]
+	v, ok := c.shard("test").keys["test"]
 	if !ok || v != "test" {
 		t.Error("Memory incorrect after set: ", v, ok)
 	}
@@ -74,8 +78,8 @@ func TestTransSet(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	if len(c.keys) > 0 {
-		t.Error("Initial memory was expected to be empty, but: ", c.keys)
+	if len(memory(c).keys) > 0 {
+		t.Error("Initial memory was expected to be empty, but: ", memory(c).keys)
 	}
 	if c.getCurrentTransaction() != nil {
 		t.Error("Initial transaction was expected to nil")
@@ -88,9 +92,12 @@ func TestTransSet(t *testing.T) {
 	if err != nil {
 		t.Error("No error was expected for set, but: ", err)
 	}
-	v, ok := c.keys["test"]
+	v, ok := c.tx.keys["test"]
 	if !ok || v != "test" {
-		t.Error("Memory incorrect after set: ", v, ok)
+		t.Error("Transaction memory incorrect after set: ", v, ok)
+	}
+	if _, ok := c.shard("test").keys["test"]; ok {
+		t.Error("Memory should not be changed before commit")
 	}
 	if len(c.tx.cmds) != 1 {
 		t.Error("Transaction commands size was expected to 1")
@@ -120,9 +127,9 @@ func TestMGet(t *testing.T) {
 	c := newCacheImpl(Driver(d))
 
 	// init memory
-	c.keys["test1"] = "1"
-	c.keys["test2"] = flagValueNil
-	c.delKeys["test5"] = ""
+	c.shard("test1").keys["test1"] = "1"
+	c.shard("test2").keys["test2"] = flagValueNil
+	c.shard("test5").delKeys["test5"] = ""
 
 	d.EXPECT().MGet([]string{"test3", "test4"}).Return(map[string]string{"test3": "t3", "test4": ""}, nil)
 
@@ -144,7 +151,7 @@ func TestMSet(t *testing.T) {
 	c := newCacheImpl(Driver(d))
 
 	// init memory
-	c.delKeys["test2"] = ""
+	c.shard("test2").delKeys["test2"] = ""
 
 	d.EXPECT().MSet(map[string]interface{}{"test1": 1, "test2": "good"}).Return(nil)
 
@@ -153,10 +160,10 @@ func TestMSet(t *testing.T) {
 	if err != nil {
 		t.Error("No error was expectected for MSet, but: ", err)
 	}
-	if len(c.delKeys) > 0 {
+	if len(memory(c).delKeys) > 0 {
 		t.Error("MSet should delete the delKeys memory")
 	}
-	if c.keys["test1"] != "1" || c.keys["test2"] != "good" {
+	if c.shard("test1").keys["test1"] != "1" || c.shard("test2").keys["test2"] != "good" {
 		t.Error("MSet memory incorrect")
 	}
 }
@@ -168,7 +175,7 @@ func TestTransMSet(t *testing.T) {
 	c := newCacheImpl(Driver(d))
 
 	// init memory
-	c.delKeys["test2"] = ""
+	c.shard("test2").delKeys["test2"] = ""
 
 	// d.EXPECT().MSet(map[string]interface{}{"test1": 1, "test2": "good"}).Return(nil)
 	tx := c.BeginTransaction()
@@ -178,11 +185,11 @@ func TestTransMSet(t *testing.T) {
 	if err != nil {
 		t.Error("No error was expectected for MSet, but: ", err)
 	}
-	if len(c.delKeys) > 0 {
-		t.Error("MSet should delete the delKeys memory")
+	if c.tx.keys["test1"] != "1" || c.tx.keys["test2"] != "good" {
+		t.Error("MSet transaction memory incorrect")
 	}
-	if c.keys["test1"] != "1" || c.keys["test2"] != "good" {
-		t.Error("MSet memory incorrect")
+	if v, err := c.Get("test2"); err != nil || v != "good" {
+		t.Error("Get was expected to read transaction memory, but: ", v, err)
 	}
 	if len(c.tx.cmds) != 1 || c.tx.cmds[0].t != typeMSet {
 		t.Error("Transaction first command type was expected to typeMSet")
@@ -192,6 +199,12 @@ func TestTransMSet(t *testing.T) {
 	if err != nil {
 		t.Error("No error was expected for transaction commit, but: ", err)
 	}
+	if len(memory(c).delKeys) > 0 {
+		t.Error("MSet should delete the delKeys memory")
+	}
+	if c.shard("test1").keys["test1"] != "1" || c.shard("test2").keys["test2"] != "good" {
+		t.Error("MSet memory incorrect")
+	}
 	if c.tx.active {
 		t.Error("Transaction status should be inactive after commit")
 	}
@@ -203,7 +216,7 @@ func TestDel(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.keys["test"] = "ok"
+	c.shard("test").keys["test"] = "ok"
 
 	d.EXPECT().Del("test").Return(nil)
 
@@ -212,7 +225,7 @@ func TestDel(t *testing.T) {
 	if err != nil {
 		t.Error("No error was expected for del, but: ", err)
 	}
-	if len(c.keys) > 0 || len(c.delKeys) == 0 {
+	if len(memory(c).keys) > 0 || len(memory(c).delKeys) == 0 {
 		t.Error("Del should update memory keys and delKeys")
 	}
 	_, err = c.Get("test")
@@ -227,7 +240,7 @@ func TestTransDel(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.keys["test"] = "ok"
+	c.shard("test").keys["test"] = "ok"
 
 	tx := c.BeginTransaction()
 	err := c.Del("test")
@@ -235,8 +248,8 @@ func TestTransDel(t *testing.T) {
 	if err != nil {
 		t.Error("No error was expected for del, but: ", err)
 	}
-	if len(c.keys) > 0 || len(c.delKeys) == 0 {
-		t.Error("Del should update memory keys and delKeys")
+	if !c.tx.delKeys["test"] || c.shard("test").keys["test"] != "ok" {
+		t.Error("Del should update transaction memory only")
 	}
 	_, err = c.Get("test")
 	if err != ErrValueNil {
@@ -251,6 +264,9 @@ func TestTransDel(t *testing.T) {
 	if err != nil {
 		t.Error("No error was expected for transaction commit, but: ", err)
 	}
+	if len(memory(c).keys) > 0 || len(memory(c).delKeys) == 0 {
+		t.Error("Del should update memory keys and delKeys after commit")
+	}
 	if c.tx.active {
 		t.Error("Transaction status should be inactive after commit")
 	}
@@ -262,9 +278,9 @@ func TestExists(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.delKeys["test"] = ""
-	c.keys["test1"] = "ok"
-	c.keys["test2"] = flagValueNil
+	c.shard("test").delKeys["test"] = ""
+	c.shard("test1").keys["test1"] = "ok"
+	c.shard("test2").keys["test2"] = flagValueNil
 
 	d.EXPECT().Exists("test3").Return(true, nil)
 
@@ -369,7 +385,7 @@ func TestIncr(t *testing.T) {
 	if v != "22" {
 		t.Error("Increased value was incorrect")
 	}
-	if c.keys["test1"] != "22" {
+	if c.shard("test1").keys["test1"] != "22" {
 		t.Error("Increase memory was not updated")
 	}
 }
@@ -392,7 +408,7 @@ func TestTransIncrCommit(t *testing.T) {
 	if v != "22" {
 		t.Error("Increased value was incorrect")
 	}
-	if c.keys["test1"] != "22" {
+	if c.tx.keys["test1"] != "22" {
 		t.Error("Increase memory was not updated")
 	}
 
@@ -429,7 +445,7 @@ func TestTransIncrRollback(t *testing.T) {
 	if v != "22" {
 		t.Error("Increased value was incorrect")
 	}
-	if c.keys["test1"] != "22" {
+	if c.tx.keys["test1"] != "22" {
 		t.Error("Increase memory was not updated")
 	}
 	if len(c.tx.cmds) != 1 || c.tx.cmds[0].t != typeIncr {
@@ -468,7 +484,7 @@ func TestDecr(t *testing.T) {
 	if v != "22" {
 		t.Error("Decreased value was incorrect")
 	}
-	if c.keys["test1"] != "22" {
+	if c.shard("test1").keys["test1"] != "22" {
 		t.Error("Decreased memory was not updated")
 	}
 }
@@ -491,7 +507,7 @@ func TestTransDecrCommit(t *testing.T) {
 	if v != "22" {
 		t.Error("Decreased value was incorrect")
 	}
-	if c.keys["test1"] != "22" {
+	if c.tx.keys["test1"] != "22" {
 		t.Error("Decreased memory was not updated")
 	}
 
@@ -528,7 +544,7 @@ func TestTransDecrRollback(t *testing.T) {
 	if v != "22" {
 		t.Error("Decreased value was incorrect")
 	}
-	if c.keys["test1"] != "22" {
+	if c.tx.keys["test1"] != "22" {
 		t.Error("Decreased memory was not updated")
 	}
 	if len(c.tx.cmds) != 1 || c.tx.cmds[0].t != typeDecr {
@@ -551,8 +567,8 @@ func TestHGet(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.delKeys["test1"] = ""
-	c.hsets = map[string]map[string]string{
+	c.shard("test1").delKeys["test1"] = ""
+	c.shard("hash").hsets = map[string]map[string]string{
 		"hash": map[string]string{
 			"test1": "1",
 			"test2": "good",
@@ -560,6 +576,7 @@ func TestHGet(t *testing.T) {
 	}
 
 	d.EXPECT().HGet("hash", "test3").Return("OK", nil)
+	d.EXPECT().HTTL("hash", "test3").Return(map[string]time.Duration{"test3": -1}, nil)
 
 	_, err := c.HGet("test1", "a")
 	if err != ErrValueNil {
@@ -587,7 +604,7 @@ func TestHSet(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.delKeys["hash"] = ""
+	c.shard("hash").delKeys["hash"] = ""
 
 	d.EXPECT().HSet("hash", "test1", 1).Return(nil)
 
@@ -596,14 +613,14 @@ func TestHSet(t *testing.T) {
 	if err != nil {
 		t.Error("No error was expected, but: ", err)
 	}
-	m, ok := c.hsets["hash"]
+	m, ok := c.shard("hash").hsets["hash"]
 	if !ok {
 		t.Error("HSet memory is not set")
 	}
 	if m["test1"] != "1" {
 		t.Error("HSet memory is not set correctly")
 	}
-	if _, ok := c.delKeys["hash"]; ok {
+	if _, ok := c.shard("hash").delKeys["hash"]; ok {
 		t.Error("HSet delete keys is not cleaned")
 	}
 }
@@ -615,22 +632,22 @@ func TestTransHSet(t *testing.T) {
 	c := newCacheImpl(Driver(d))
 
 	tx := c.BeginTransaction()
-	c.delKeys["hash"] = ""
+	c.shard("hash").delKeys["hash"] = ""
 
 	err := c.HSet("hash", "test1", 1)
 
 	if err != nil {
 		t.Error("No error was expected, but: ", err)
 	}
-	m, ok := c.hsets["hash"]
+	m, ok := c.tx.hsets["hash"]
 	if !ok {
 		t.Error("HSet memory is not set")
 	}
 	if m["test1"] != "1" {
 		t.Error("HSet memory is not set correctly")
 	}
-	if _, ok := c.delKeys["hash"]; ok {
-		t.Error("HSet delete keys is not cleaned")
+	if _, ok := c.shard("hash").delKeys["hash"]; !ok {
+		t.Error("Memory should not be changed before commit")
 	}
 	if len(c.tx.cmds) != 1 || c.tx.cmds[0].t != typeHSet {
 		t.Error("Transaction first command type was expected to typeHSet")
@@ -652,7 +669,7 @@ func TestHMGetAllHit(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.hsets["hash"] = map[string]string{
+	c.shard("hash").hsets["hash"] = map[string]string{
 		"test1": "1",
 		"test2": "good",
 		"test3": "ok",
@@ -677,7 +694,7 @@ func TestHMGetPartHit(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.hsets["hash"] = map[string]string{
+	c.shard("hash").hsets["hash"] = map[string]string{
 		"test1": "1",
 		"test2": "good",
 		"test3": "ok",
@@ -685,6 +702,7 @@ func TestHMGetPartHit(t *testing.T) {
 	}
 
 	d.EXPECT().HMGet("hash", []string{"test5", "test6"}).Return(map[string]string{"test5": "test5"}, nil)
+	d.EXPECT().HTTL("hash", "test5", "test6").Return(map[string]time.Duration{"test5": -1}, nil)
 
 	res, err := c.HMGet("hash", []string{"test1", "test3", "test4", "test5", "test6"})
 	if err != nil {
@@ -696,7 +714,7 @@ func TestHMGetPartHit(t *testing.T) {
 	if res["test1"] != "1" || res["test3"] != "ok" || res["test4"] != "" || res["test5"] != "test5" || res["test6"] != "" {
 		t.Error("Result was incorrect")
 	}
-	if c.hsets["hash"]["test5"] != "test5" {
+	if c.shard("hash").hsets["hash"]["test5"] != "test5" {
 		t.Error("Memory was not updated")
 	}
 }
@@ -707,7 +725,7 @@ func TestHMGetDeleted(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.delKeys["hash"] = ""
+	c.shard("hash").delKeys["hash"] = ""
 
 	res, err := c.HMGet("hash", []string{"test1", "test3", "test4", "test5", "test6"})
 	if err != nil {
@@ -724,8 +742,8 @@ func TestHMSet(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.delKeys["hash"] = ""
-	c.hsets["hash"] = map[string]string{
+	c.shard("hash").delKeys["hash"] = ""
+	c.shard("hash").hsets["hash"] = map[string]string{
 		"test1": "1",
 		"test2": "good",
 		"test3": "ok",
@@ -739,10 +757,10 @@ func TestHMSet(t *testing.T) {
 	if err != nil {
 		t.Error("No error was expected")
 	}
-	if _, ok := c.delKeys["hash"]; ok {
+	if _, ok := c.shard("hash").delKeys["hash"]; ok {
 		t.Error("Memory deleted key should be empty")
 	}
-	m := c.hsets["hash"]
+	m := c.shard("hash").hsets["hash"]
 	if m["test1"] != "10" || m["test2"] != "good" || m["test3"] != "ok" || m["test4"] != "tt" || m["test5"] != "test5" {
 		t.Error("Memory hset was not updated")
 	}
@@ -754,8 +772,8 @@ func TestTransHMSet(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.delKeys["hash"] = ""
-	c.hsets["hash"] = map[string]string{
+	c.shard("hash").delKeys["hash"] = ""
+	c.shard("hash").hsets["hash"] = map[string]string{
 		"test1": "1",
 		"test2": "good",
 		"test3": "ok",
@@ -769,12 +787,12 @@ func TestTransHMSet(t *testing.T) {
 	if err != nil {
 		t.Error("No error was expected")
 	}
-	if _, ok := c.delKeys["hash"]; ok {
-		t.Error("Memory deleted key should be empty")
+	m := c.tx.hsets["hash"]
+	if m["test1"] != "10" || m["test4"] != "tt" || m["test5"] != "test5" {
+		t.Error("Transaction memory hset was not updated")
 	}
-	m := c.hsets["hash"]
-	if m["test1"] != "10" || m["test2"] != "good" || m["test3"] != "ok" || m["test4"] != "tt" || m["test5"] != "test5" {
-		t.Error("Memory hset was not updated")
+	if c.shard("hash").hsets["hash"]["test1"] != "1" {
+		t.Error("Memory hset should not be changed before commit")
 	}
 	if len(c.tx.cmds) != 1 || c.tx.cmds[0].t != typeHMSet {
 		t.Error("Transaction first command type was expected to typeHMSet")
@@ -812,7 +830,7 @@ func TestHIncr(t *testing.T) {
 	if v != "22" {
 		t.Error("Increased value was incorrect")
 	}
-	if c.hsets["hash"]["test1"] != "22" {
+	if c.shard("hash").hsets["hash"]["test1"] != "22" {
 		t.Error("Increase memory was not updated")
 	}
 }
@@ -835,7 +853,7 @@ func TestTransHIncrCommit(t *testing.T) {
 	if v != "22" {
 		t.Error("Increased value was incorrect")
 	}
-	if c.hsets["hash"]["test1"] != "22" {
+	if c.tx.hsets["hash"]["test1"] != "22" {
 		t.Error("Increase memory was not updated")
 	}
 
@@ -875,7 +893,7 @@ func TestTransHIncrRollback(t *testing.T) {
 	if v != "22" {
 		t.Error("Increased value was incorrect")
 	}
-	if c.hsets["hash"]["test1"] != "22" {
+	if c.tx.hsets["hash"]["test1"] != "22" {
 		t.Error("Increase memory was not updated")
 	}
 	if len(c.tx.cmds) != 1 || c.tx.cmds[0].t != typeHIncr {
@@ -917,7 +935,7 @@ func TestHDecr(t *testing.T) {
 	if v != "22" {
 		t.Error("Decreased value was incorrect")
 	}
-	if c.hsets["hash"]["test1"] != "22" {
+	if c.shard("hash").hsets["hash"]["test1"] != "22" {
 		t.Error("Decrease memory was not updated")
 	}
 }
@@ -940,7 +958,7 @@ func TestTransHDecrCommit(t *testing.T) {
 	if v != "22" {
 		t.Error("Decreased value was incorrect")
 	}
-	if c.hsets["hash"]["test1"] != "22" {
+	if c.tx.hsets["hash"]["test1"] != "22" {
 		t.Error("Decrease memory was not updated")
 	}
 
@@ -980,7 +998,7 @@ func TestTransHDecrRollback(t *testing.T) {
 	if v != "22" {
 		t.Error("Decreased value was incorrect")
 	}
-	if c.hsets["hash"]["test1"] != "22" {
+	if c.tx.hsets["hash"]["test1"] != "22" {
 		t.Error("Decrease memory was not updated")
 	}
 	if len(c.tx.cmds) != 1 || c.tx.cmds[0].t != typeHDecr {
@@ -1006,7 +1024,7 @@ func TestHGetAll(t *testing.T) {
 	d := dmock.NewMockDriver(ctrl)
 	c := newCacheImpl(Driver(d))
 
-	c.hsets["hash"] = map[string]string{
+	c.shard("hash").hsets["hash"] = map[string]string{
 		"test1": "tt",
 		"test2": flagValueNil,
 	}
@@ -1022,3 +1040,1719 @@ func TestHGetAll(t *testing.T) {
 		t.Error("Result was incorrect")
 	}
 }
+
+func TestXAdd(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().XAdd("stream", "*", map[string]interface{}{"a": 1}).Return("1-0", nil)
+
+	id, err := c.XAdd("stream", "*", map[string]interface{}{"a": 1})
+	if err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	if id != "1-0" {
+		t.Error("XAdd id was expected to '1-0', but: ", id)
+	}
+}
+
+func TestTransXAdd(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+
+	id, err := c.XAdd("stream", "*", map[string]interface{}{"a": 1})
+	if err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	if id != "" {
+		t.Error("XAdd id was expected to be empty inside transaction, but: ", id)
+	}
+	id, err = c.XAdd("stream", "5-0", map[string]interface{}{"b": 2})
+	if err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	if id != "5-0" {
+		t.Error("XAdd id was expected to '5-0', but: ", id)
+	}
+	if len(c.tx.cmds) != 2 || c.tx.cmds[0].t != typeXAdd {
+		t.Error("Transaction first command type was expected to typeXAdd")
+	}
+
+	gomock.InOrder(
+		d.EXPECT().XAdd("stream", "*", map[string]interface{}{"a": 1}).Return("1-0", nil),
+		d.EXPECT().XAdd("stream", "5-0", map[string]interface{}{"b": 2}).Return("5-0", nil),
+	)
+	err = tx.Commit()
+	if err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+}
+
+func TestTransXAddRollback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	c.XAdd("stream", "*", map[string]interface{}{"a": 1})
+
+	err := tx.Rollback()
+	if err != nil {
+		t.Error("No error was expected for transaction rollback, but: ", err)
+	}
+}
+
+func TestXReadGroup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	ret := []driver.Stream{{Key: "stream", Messages: []driver.StreamMessage{{ID: "1-0", Values: map[string]string{"a": "1"}}}}}
+	d.EXPECT().XReadGroup("group", "consumer", map[string]string{"stream": ">"}, int64(1), time.Second).Return(ret, nil)
+
+	ss, err := c.XReadGroup("group", "consumer", map[string]string{"stream": ">"}, 1, time.Second)
+	if err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	if len(ss) != 1 || ss[0].Messages[0].ID != "1-0" {
+		t.Error("XReadGroup result was incorrect: ", ss)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().Publish("news", "hello").Return(int64(1), nil)
+
+	n, err := c.Publish("news", "hello")
+	if err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	if n != 1 {
+		t.Error("Publish receivers was expected to 1, but: ", n)
+	}
+}
+
+func TestTransPublish(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	n, err := c.Publish("news", "hello")
+	if err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	if n != 0 {
+		t.Error("Publish receivers was expected to 0 inside transaction, but: ", n)
+	}
+	if len(c.tx.cmds) != 1 || c.tx.cmds[0].t != typePublish {
+		t.Error("Transaction first command type was expected to typePublish")
+	}
+
+	d.EXPECT().Publish("news", "hello").Return(int64(1), nil)
+	err = tx.Commit()
+	if err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+}
+
+func TestEval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	c.shard("test1").keys["test1"] = "1"
+	c.shard("test2").keys["test2"] = "2"
+	c.shard("hash").hsets["hash"] = map[string]string{"k": "v"}
+	s := driver.NewScript(2, "redis.call('INCR', KEYS[1]); redis.call('HDEL', KEYS[2], ARGV[1])")
+
+	d.EXPECT().Eval(s, "test1", "hash", "k").Return(int64(1), nil)
+
+	v, err := c.Eval(s, "test1", "hash", "k")
+	if err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	if v != int64(1) {
+		t.Error("Eval result was expected to 1, but: ", v)
+	}
+	if _, ok := c.shard("test1").keys["test1"]; ok {
+		t.Error("Script key 'test1' should be dropped from memory")
+	}
+	if _, ok := c.shard("hash").hsets["hash"]; ok {
+		t.Error("Script key 'hash' should be dropped from memory")
+	}
+	if c.shard("test2").keys["test2"] != "2" {
+		t.Error("Key 'test2' not touched by script should stay in memory")
+	}
+}
+
+func TestEvalSha(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	c.shard("test1").keys["test1"] = "1"
+	c.shard("test2").delKeys["test2"] = ""
+
+	d.EXPECT().EvalSha("abc", 2, "test1", "test2", 10).Return("OK", nil)
+
+	_, err := c.EvalSha("abc", 2, "test1", "test2", 10)
+	if err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	if len(memory(c).keys) > 0 || len(memory(c).delKeys) > 0 {
+		t.Error("Script keys should be dropped from memory")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := newCacheImpl()
+
+	c.shard("test1").keys["test1"] = "1"
+	c.shard("test2").delKeys["test2"] = ""
+	c.shard("hash").hsets["hash"] = map[string]string{"k": "v"}
+
+	c.Invalidate("test1", "test2", "hash")
+	if len(memory(c).keys) > 0 || len(memory(c).delKeys) > 0 || len(memory(c).hsets) > 0 {
+		t.Error("Memory should be empty after invalidate")
+	}
+}
+
+func TestExpireDuration(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+	tm := time.Now().Add(time.Minute)
+
+	d.EXPECT().PExpire("test", int64(250)).Return(nil)
+	d.EXPECT().ExpireDuration("test", 250*time.Millisecond).Return(nil)
+	d.EXPECT().ExpireAt("test", tm).Return(nil)
+
+	if err := c.PExpire("test", 250); err != nil {
+		t.Error("No error was expected for pexpire, but: ", err)
+	}
+	if err := c.ExpireDuration("test", 250*time.Millisecond); err != nil {
+		t.Error("No error was expected for expire duration, but: ", err)
+	}
+	if err := c.ExpireAt("test", tm); err != nil {
+		t.Error("No error was expected for expire at, but: ", err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().TTL("test1").Return(time.Second, nil)
+	d.EXPECT().TTL("test2").Return(time.Duration(0), driver.ErrValueNil)
+
+	if ttl, err := c.TTL("test1"); err != nil || ttl != time.Second {
+		t.Error("TTL return value incorrect: ", ttl, err)
+	}
+	if _, err := c.TTL("test2"); err != ErrValueNil {
+		t.Error("ErrValueNil was expected for TTL of missing key, but: ", err)
+	}
+}
+
+func TestTransExpireDuration(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+	tm := time.Now().Add(time.Minute)
+
+	tx := c.BeginTransaction()
+	c.PExpire("test", 250)
+	c.ExpireDuration("test", 250*time.Millisecond)
+	c.ExpireAt("test", tm)
+
+	if len(c.tx.cmds) != 3 || c.tx.cmds[0].t != typePExpire || c.tx.cmds[1].t != typeExpireDuration || c.tx.cmds[2].t != typeExpireAt {
+		t.Error("Transaction commands were expected to typePExpire, typeExpireDuration and typeExpireAt")
+	}
+
+	gomock.InOrder(
+		d.EXPECT().PExpire("test", int64(250)).Return(nil),
+		d.EXPECT().ExpireDuration("test", 250*time.Millisecond).Return(nil),
+		d.EXPECT().ExpireAt("test", tm).Return(nil),
+	)
+	err := tx.Commit()
+	if err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+}
+
+func TestHExpire(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	c.shard("hash").hsets["hash"] = map[string]string{
+		"test1": "1",
+		"test2": "2",
+	}
+
+	d.EXPECT().HPExpire("hash", int64(1), "test1").Return(nil)
+	d.EXPECT().HExpire("hash", int64(60), "test2").Return(nil)
+
+	if err := c.HPExpire("hash", 1, "test1"); err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	if err := c.HExpire("hash", 60, "test2"); err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	_, err := c.HGet("hash", "test1")
+	if err != ErrValueNil {
+		t.Error("Expired hash key was expected to be nil, but: ", err)
+	}
+	v, err := c.HGet("hash", "test2")
+	if err != nil || v != "2" {
+		t.Error("Hash key not expired yet was expected to '2', but: ", v, err)
+	}
+	b, _ := c.HExists("hash", "test1")
+	if b {
+		t.Error("Expired hash key should not exist")
+	}
+}
+
+func TestHPersist(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	c.shard("hash").hsets["hash"] = map[string]string{"test1": "1"}
+
+	d.EXPECT().HPExpire("hash", int64(1), "test1").Return(nil)
+	d.EXPECT().HPersist("hash", "test1").Return(nil)
+
+	c.HPExpire("hash", 1, "test1")
+	if err := c.HPersist("hash", "test1"); err != nil {
+		t.Error("No error was expected, but: ", err)
+	}
+	if len(memory(c).hexpires) > 0 {
+		t.Error("Memory hash key expiration should be removed after persist")
+	}
+	time.Sleep(2 * time.Millisecond)
+	v, err := c.HGet("hash", "test1")
+	if err != nil || v != "1" {
+		t.Error("Persisted hash key was expected to '1', but: ", v, err)
+	}
+}
+
+func TestHGetDriverHExpire(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	gomock.InOrder(
+		d.EXPECT().HGet("hash", "test1").Return("1", nil),
+		d.EXPECT().HTTL("hash", "test1").Return(map[string]time.Duration{"test1": time.Millisecond}, nil),
+		d.EXPECT().HMGet("hash", []string{"test2", "test3"}).Return(map[string]string{"test2": "2", "test3": "3"}, nil),
+		d.EXPECT().HTTL("hash", "test2", "test3").Return(map[string]time.Duration{"test2": time.Millisecond, "test3": -1}, nil),
+		d.EXPECT().HGet("hash", "test4").Return("4", nil),
+		d.EXPECT().HTTL("hash", "test4").Return(nil, errors.New("test")),
+	)
+	c.HGet("hash", "test1")
+	c.HMGet("hash", []string{"test2", "test3"})
+	c.HGet("hash", "test4")
+	if _, ok := c.shard("hash").hsets["hash"]["test4"]; ok {
+		t.Error("Hash key with unknown expiration should not be kept in memory")
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	if _, err := c.HGet("hash", "test1"); err != ErrValueNil {
+		t.Error("Hash key expired on driver was expected to be nil, but: ", err)
+	}
+	res, _ := c.HMGet("hash", []string{"test2", "test3"})
+	if res["test2"] != "" || res["test3"] != "3" {
+		t.Error("Hash keys were expected to expire as on driver, but: ", res)
+	}
+}
+
+func TestHSetClearsHExpire(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().HExpire("hash", int64(60), "test1").Return(nil)
+	d.EXPECT().HSet("hash", "test1", "v").Return(nil)
+
+	c.HExpire("hash", 60, "test1")
+	c.HSet("hash", "test1", "v")
+	if len(memory(c).hexpires) > 0 {
+		t.Error("Memory hash key expiration should be removed after HSet")
+	}
+}
+
+func TestTransHExpire(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	c.HSet("hash", "test1", "v")
+	c.HExpire("hash", 60, "test1", "test2")
+	c.HPExpire("hash", 500, "test3")
+	c.HPersist("hash", "test2")
+
+	if len(c.tx.cmds) != 4 || c.tx.cmds[1].t != typeHExpire || c.tx.cmds[2].t != typeHPExpire || c.tx.cmds[3].t != typeHPersist {
+		t.Error("Transaction commands were expected to typeHExpire, typeHPExpire and typeHPersist")
+	}
+	if _, ok := c.tx.hexpires["hash"]["test1"]; !ok {
+		t.Error("Memory hash key expiration was expected to be set")
+	}
+
+	gomock.InOrder(
+		d.EXPECT().HSet("hash", "test1", "v").Return(nil),
+		d.EXPECT().HExpire("hash", int64(60), "test1", "test2").Return(nil),
+		d.EXPECT().HPExpire("hash", int64(500), "test3").Return(nil),
+		d.EXPECT().HPersist("hash", "test2").Return(nil),
+	)
+	err := tx.Commit()
+	if err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+}
+
+// batchDriver mocked driver supporting batch
+type batchDriver struct {
+	*dmock.MockDriver
+	batches int
+}
+
+func (d *batchDriver) Batch(fn func(driver.Driver) error) error {
+	d.batches++
+	return fn(d.MockDriver)
+}
+
+func TestTransCommitBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := &batchDriver{MockDriver: dmock.NewMockDriver(ctrl)}
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "ok")
+	c.Del("test2")
+	c.HSet("hash", "k1", 1)
+
+	gomock.InOrder(
+		d.EXPECT().Set("test1", "ok").Return(driver.ErrQueued),
+		d.EXPECT().Del("test2").Return(driver.ErrQueued),
+		d.EXPECT().HSet("hash", "k1", 1).Return(driver.ErrQueued),
+	)
+	err := tx.Commit()
+	if err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+	if d.batches != 1 {
+		t.Error("Transaction commit was expected to run in one batch, but: ", d.batches)
+	}
+}
+
+func TestTransCommitBatchError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := &batchDriver{MockDriver: dmock.NewMockDriver(ctrl)}
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "ok")
+	c.Set("test2", "ok")
+
+	d.EXPECT().Set("test1", "ok").Return(errors.New("test"))
+	err := tx.Commit()
+	ce, ok := err.(*CommitError)
+	if !ok {
+		t.Fatal("CommitError was expected for transaction commit, but: ", err)
+	}
+	// batch discarded, none of the commands applied
+	if len(ce.Failed) != 2 || ce.Failed[0].Key != "test1" || ce.Failed[1].Key != "test2" || ce.Failed[0].Err.Error() != "test" {
+		t.Error("Both commands were expected to fail, but: ", ce)
+	}
+	if len(memory(c).keys) > 0 {
+		t.Error("Failed commands should be dropped from memory")
+	}
+}
+
+// failingBatchDriver mocked driver reporting failures of batch commands
+type failingBatchDriver struct {
+	*dmock.MockDriver
+	errs map[int]error
+}
+
+func (d *failingBatchDriver) Batch(fn func(driver.Driver) error) error {
+	if err := fn(d.MockDriver); err != nil {
+		return err
+	}
+	return &driver.BatchError{Errors: d.errs}
+}
+
+func TestTransCommitBatchCommandError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := &failingBatchDriver{MockDriver: dmock.NewMockDriver(ctrl), errs: map[int]error{1: errors.New("WRONGTYPE")}}
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().Incr("counter", 1).Return("1", nil)
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "ok")
+	c.Incr("counter", 1)
+	c.HSet("hash", "k1", "v")
+
+	d.EXPECT().Set("test1", "ok").Return(driver.ErrQueued)
+	d.EXPECT().HSet("hash", "k1", "v").Return(driver.ErrQueued)
+	err := tx.Commit()
+	ce, ok := err.(*CommitError)
+	if !ok {
+		t.Fatal("CommitError was expected for transaction commit, but: ", err)
+	}
+	if len(ce.Failed) != 1 || ce.Failed[0].Op != "HSet" || ce.Failed[0].Key != "hash" || ce.Failed[0].Err.Error() != "WRONGTYPE" {
+		t.Error("HSet was expected to fail, but: ", ce)
+	}
+	if c.shard("test1").keys["test1"] != "ok" {
+		t.Error("Applied command should stay in memory")
+	}
+	if _, ok := c.shard("hash").hsets["hash"]; ok {
+		t.Error("Failed command should be dropped from memory")
+	}
+}
+
+func TestTransCommitStopOnError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "ok")
+	c.MSet(map[string]interface{}{"test2": 2, "test3": 3})
+	c.HDel("hash", "k1")
+
+	gomock.InOrder(
+		d.EXPECT().Set("test1", "ok").Return(nil),
+		d.EXPECT().MSet(map[string]interface{}{"test2": 2, "test3": 3}).Return(errors.New("test")),
+	)
+	err := tx.Commit()
+	ce, ok := err.(*CommitError)
+	if !ok {
+		t.Fatal("CommitError was expected for transaction commit, but: ", err)
+	}
+	if len(ce.Failed) != 2 || ce.Failed[0].Op != "MSet" || ce.Failed[1].Op != "HDel" || ce.Failed[1].Err != ErrNotApplied {
+		t.Error("MSet was expected to fail and HDel not to be applied, but: ", ce)
+	}
+	if ce.Failed[1].Key != "hash" || len(ce.Failed[1].Args) != 1 || ce.Failed[1].Args[0] != "k1" {
+		t.Error("Failed command key and arguments were incorrect: ", ce.Failed[1])
+	}
+	if c.shard("test1").keys["test1"] != "ok" {
+		t.Error("Applied command should stay in memory")
+	}
+	if _, ok := c.shard("test2").keys["test2"]; ok {
+		t.Error("Failed command should be dropped from memory")
+	}
+	if _, ok := c.shard("hash").hsets["hash"]; ok {
+		t.Error("Command not applied should be dropped from memory")
+	}
+}
+
+func TestTransCommitContinueOnError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), OnCommitError(ContinueOnError))
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "ok")
+	c.Set("test2", "ok")
+	c.Del("test3")
+
+	gomock.InOrder(
+		d.EXPECT().Set("test1", "ok").Return(errors.New("test")),
+		d.EXPECT().Set("test2", "ok").Return(nil),
+		d.EXPECT().Del("test3").Return(nil),
+	)
+	err := tx.Commit()
+	ce, ok := err.(*CommitError)
+	if !ok {
+		t.Fatal("CommitError was expected for transaction commit, but: ", err)
+	}
+	if len(ce.Failed) != 1 || ce.Failed[0].Key != "test1" {
+		t.Error("Only Set of 'test1' was expected to fail, but: ", ce)
+	}
+	if _, ok := c.shard("test1").keys["test1"]; ok {
+		t.Error("Failed command should be dropped from memory")
+	}
+	if c.shard("test2").keys["test2"] != "ok" {
+		t.Error("Applied command should stay in memory")
+	}
+	if _, ok := c.shard("test3").delKeys["test3"]; !ok {
+		t.Error("Applied delete should stay in memory")
+	}
+}
+
+func TestTransWatchConflict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+	c.shard("test1").keys["test1"] = "1"
+
+	tx := c.BeginTransaction()
+	if err := tx.Watch("test1"); err != nil {
+		t.Fatal("No error was expected to watch, but: ", err)
+	}
+	if _, ok := c.shard("test1").keys["test1"]; ok {
+		t.Error("Watched key should be dropped from memory")
+	}
+	c.Set("test2", "ok")
+	c.touch("test1") // changed by another writer
+
+	err := tx.Commit()
+	if err != ErrConflict {
+		t.Error("ErrConflict was expected for transaction commit, but: ", err)
+	}
+	if _, ok := c.shard("test2").keys["test2"]; ok {
+		t.Error("Writes of conflicting transaction should be dropped from memory")
+	}
+}
+
+// watchDriver mocked driver supporting watch, batches conflict until conflicts reach zero
+type watchDriver struct {
+	*dmock.MockDriver
+	conflicts int
+	watched   []string
+	closed    int
+}
+
+func (d *watchDriver) Watch(keys ...string) (driver.WatchSession, error) {
+	s := &watchSession{d: d}
+	return s, s.Watch(keys...)
+}
+
+// watchSession watch session of watchDriver
+type watchSession struct {
+	d *watchDriver
+}
+
+func (s *watchSession) Watch(keys ...string) error {
+	s.d.watched = append(s.d.watched, keys...)
+	return nil
+}
+
+func (s *watchSession) Batch(fn func(driver.Driver) error) error {
+	if s.d.conflicts > 0 {
+		s.d.conflicts--
+		return driver.ErrConflict
+	}
+	return fn(s.d.MockDriver)
+}
+
+func (s *watchSession) Close() error {
+	s.d.closed++
+	return nil
+}
+
+func TestTransWatchConflictNoWrites(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := &watchDriver{MockDriver: dmock.NewMockDriver(ctrl), conflicts: 1}
+	c := newCacheImpl(Driver(d))
+
+	gomock.InOrder(
+		d.EXPECT().Incr("counter", 1).Return("1", nil),
+		d.EXPECT().Decr("counter", 1).Return("0", nil), // compensated
+	)
+	tx := c.BeginTransaction()
+	tx.Watch("test1")
+	tx.Incr("counter", 1) // not deferred, applied at once
+	if err := tx.Commit(); err != ErrConflict {
+		t.Error("ErrConflict was expected for transaction without writes, but: ", err)
+	}
+}
+
+func TestRunInTransaction(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := &watchDriver{MockDriver: dmock.NewMockDriver(ctrl), conflicts: 1}
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().Get("test1").Return("1", nil).Times(2)
+	d.EXPECT().Set("test1", "2").Return(driver.ErrQueued)
+
+	runs := 0
+	err := c.RunInTransaction(func(tx Transaction) error {
+		runs++
+		if err := tx.Watch("test1"); err != nil {
+			return err
+		}
+		if v, _ := tx.Get("test1"); v != "1" {
+			t.Error("Watched key was expected to be read from driver, but: ", v)
+		}
+		return tx.Set("test1", "2")
+	}, 3)
+	if err != nil {
+		t.Error("No error was expected to run in transaction, but: ", err)
+	}
+	if runs != 2 || d.closed != 2 {
+		t.Error("Transaction was expected to run twice, but: ", runs, d.closed)
+	}
+	if len(d.watched) != 2 || c.shard("test1").keys["test1"] != "2" {
+		t.Error("Watched key was expected to be read again: ", d.watched, c.shard("test1").keys["test1"])
+	}
+}
+
+func TestRunInTransactionRetriesExhausted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := &watchDriver{MockDriver: dmock.NewMockDriver(ctrl), conflicts: 5}
+	c := newCacheImpl(Driver(d))
+
+	runs := 0
+	err := c.RunInTransaction(func(tx Transaction) error {
+		runs++
+		tx.Watch("test1")
+		return tx.Set("test1", "ok")
+	}, 2)
+	if err != ErrConflict {
+		t.Error("ErrConflict was expected when retries are exhausted, but: ", err)
+	}
+	if runs != 3 {
+		t.Error("Transaction was expected to run 3 times, but: ", runs)
+	}
+}
+
+func TestRunInTransactionIndependent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().Set("test2", "2").Return(nil)
+	d.EXPECT().Set("test1", "1").Return(nil)
+	err := c.RunInTransaction(func(tx Transaction) error {
+		tx.Set("test1", "1")
+		// run by another handler meanwhile
+		return c.RunInTransaction(func(tx Transaction) error {
+			return tx.Set("test2", "2")
+		}, 0)
+	}, 0)
+	if err != nil || c.getCurrentTransaction() != nil {
+		t.Error("Transactions were expected to be committed independently, but: ", err)
+	}
+}
+
+func TestTransRollbackMemory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+	c.shard("test1").keys["test1"] = "ok"
+	c.shard("test2").keys["test2"] = "ok"
+	c.shard("hash").hsets["hash"] = map[string]string{"k1": "v1"}
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "changed")
+	c.Del("test2")
+	c.HSet("hash", "k1", "changed")
+	c.HDel("hash", "k1")
+
+	if v, _ := c.Get("test1"); v != "changed" {
+		t.Error("Get was expected to read transaction memory, but: ", v)
+	}
+	if _, err := c.Get("test2"); err != ErrValueNil {
+		t.Error("Deleted key was expected to be nil inside transaction, but: ", err)
+	}
+	if _, err := c.HGet("hash", "k1"); err != ErrValueNil {
+		t.Error("Deleted hash key was expected to be nil inside transaction, but: ", err)
+	}
+
+	tx.Rollback()
+	if v, _ := c.Get("test1"); v != "ok" {
+		t.Error("Rolled back value should not be in memory, but: ", v)
+	}
+	if v, _ := c.Get("test2"); v != "ok" {
+		t.Error("Rolled back delete should not be in memory, but: ", v)
+	}
+	if v, _ := c.HGet("hash", "k1"); v != "v1" {
+		t.Error("Rolled back hash key should not be in memory, but: ", v)
+	}
+}
+
+func TestTransCommitMemory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+	c.shard("hash").hsets["hash"] = map[string]string{"k1": "v1", "k2": "v2"}
+
+	tx := c.BeginTransaction()
+	c.Del("hash")
+	c.HSet("hash", "k2", "new")
+
+	if m, _ := c.HGetAll("hash"); len(m) != 1 || m["k2"] != "new" {
+		t.Error("HGetAll was expected to read transaction memory, but: ", m)
+	}
+
+	gomock.InOrder(
+		d.EXPECT().Del("hash").Return(nil),
+		d.EXPECT().HSet("hash", "k2", "new").Return(nil),
+	)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+	if m := c.shard("hash").hsets["hash"]; len(m) != 1 || m["k2"] != "new" {
+		t.Error("Memory was expected to be merged after commit, but: ", m)
+	}
+	if _, ok := c.shard("hash").delKeys["hash"]; ok {
+		t.Error("Deleted key should be cleaned when set again")
+	}
+}
+
+func TestTransSavepointCommit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "outer")
+	inner := c.BeginTransaction()
+	if inner == tx || c.tx.parent != tx {
+		t.Fatal("Savepoint was expected to be nested in the active transaction")
+	}
+	c.Set("test2", "inner")
+	if v, _ := c.Get("test1"); v != "outer" {
+		t.Error("Savepoint was expected to read outer transaction memory, but: ", v)
+	}
+	if err := inner.Commit(); err != nil {
+		t.Error("No error was expected for savepoint commit, but: ", err)
+	}
+	if c.getCurrentTransaction() != tx || len(c.tx.cmds) != 2 {
+		t.Error("Savepoint commands were expected to be merged into outer transaction")
+	}
+	if v, _ := c.Get("test2"); v != "inner" || len(memory(c).keys) > 0 {
+		t.Error("Savepoint memory was expected to be merged into outer transaction, but: ", v)
+	}
+
+	gomock.InOrder(
+		d.EXPECT().Set("test1", "outer").Return(nil),
+		d.EXPECT().Set("test2", "inner").Return(nil),
+	)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+	if c.shard("test1").keys["test1"] != "outer" || c.shard("test2").keys["test2"] != "inner" {
+		t.Error("Memory was expected to be merged after outermost commit, but: ", memory(c).keys)
+	}
+}
+
+func TestTransSavepointRollback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().Incr("counter", 1).Return("1", nil)
+	d.EXPECT().HIncr("hash", "k1", 2).Return("2", nil)
+
+	tx := c.BeginTransaction()
+	c.Incr("counter", 1)
+	c.Set("test1", "outer")
+	inner := c.BeginTransaction()
+	c.Set("test1", "inner")
+	c.HIncr("hash", "k1", 2)
+
+	d.EXPECT().HDecr("hash", "k1", 2).Return("0", nil)
+	if err := inner.Rollback(); err != nil {
+		t.Error("No error was expected for savepoint rollback, but: ", err)
+	}
+	if c.getCurrentTransaction() != tx || len(c.tx.cmds) != 2 {
+		t.Error("Outer transaction was expected to keep its own commands only")
+	}
+	if v, _ := c.Get("test1"); v != "outer" {
+		t.Error("Savepoint memory was expected to be discarded, but: ", v)
+	}
+
+	d.EXPECT().Set("test1", "outer").Return(nil)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+}
+
+func TestTransCommitRollbackInner(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	c.BeginTransaction()
+	c.Set("test1", "inner")
+
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+	if c.getCurrentTransaction() != nil || len(memory(c).keys) > 0 {
+		t.Error("Active savepoint was expected to be rolled back")
+	}
+}
+
+func TestNewTransaction(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+	c.shard("test1").keys["test1"] = "old"
+
+	tx1 := c.NewTransaction()
+	tx2 := c.NewTransaction()
+	if c.getCurrentTransaction() != nil {
+		t.Error("Independent transactions should not become current")
+	}
+	tx1.Set("test1", "tx1")
+	tx2.HSet("hash", "k1", "tx2")
+
+	d.EXPECT().Incr("counter", 1).Return("1", nil)
+	if v, err := tx2.Incr("counter", 1); err != nil || v != "1" {
+		t.Error("Increased value was incorrect: ", v, err)
+	}
+
+	if v, _ := tx1.Get("test1"); v != "tx1" {
+		t.Error("Transaction was expected to read its own writes, but: ", v)
+	}
+	if v, _ := tx2.Get("test1"); v != "old" {
+		t.Error("Transaction should not read writes of other transaction, but: ", v)
+	}
+	if v, _ := c.Get("test1"); v != "old" {
+		t.Error("Cache should not read uncommitted writes, but: ", v)
+	}
+
+	d.EXPECT().HSet("hash", "k1", "tx2").Return(nil)
+	if err := tx2.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+	d.EXPECT().Decr("none", 1).Return("-1", nil) // tx1 still active, counter not queued into tx2
+	c.Decr("none", 1)
+
+	d.EXPECT().Set("test1", "tx1").Return(nil)
+	if err := tx1.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+	if c.shard("test1").keys["test1"] != "tx1" || c.shard("hash").hsets["hash"]["k1"] != "tx2" || c.shard("counter").keys["counter"] != "1" {
+		t.Error("Writes of both transactions were expected in memory, but: ", memory(c).keys, memory(c).hsets)
+	}
+}
+
+// hookDriver mocked driver supporting transaction hooks
+type hookDriver struct {
+	*dmock.MockDriver
+	createErr, commitErr, rollbackErr error
+}
+
+func (d *hookDriver) BeforeCreate() error   { return d.createErr }
+func (d *hookDriver) AfterCreate() error    { return nil }
+func (d *hookDriver) BeforeCommit() error   { return d.commitErr }
+func (d *hookDriver) AfterCommit() error    { return nil }
+func (d *hookDriver) BeforeRollback() error { return d.rollbackErr }
+func (d *hookDriver) AfterRollback() error  { return nil }
+
+func TestTransHookErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := &hookDriver{MockDriver: dmock.NewMockDriver(ctrl), commitErr: errors.New("commit vetoed")}
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "ok")
+	if err := tx.Commit(); err != d.commitErr {
+		t.Error("BeforeCommit error was expected for transaction commit, but: ", err)
+	}
+	if c.getCurrentTransaction() != tx || len(c.tx.cmds) != 1 {
+		t.Error("Transaction was expected to stay active after vetoed commit")
+	}
+	d.rollbackErr = errors.New("rollback vetoed")
+	if err := tx.Rollback(); err != d.rollbackErr {
+		t.Error("BeforeRollback error was expected for transaction rollback, but: ", err)
+	}
+	d.rollbackErr = nil
+	if err := tx.Rollback(); err != nil || c.getCurrentTransaction() != nil {
+		t.Error("Transaction was expected to be rolled back, but: ", err)
+	}
+
+	d.createErr = errors.New("create failed")
+	tx = c.BeginTransaction()
+	if tx.Err() != d.createErr {
+		t.Error("BeforeCreate error was expected, but: ", tx.Err())
+	}
+	if err := c.Set("test1", "ok"); err != d.createErr || len(c.tx.cmds) != 0 {
+		t.Error("BeforeCreate error was expected for commands of failed transaction, but: ", err)
+	}
+	if _, err := tx.Get("test1"); err != d.createErr {
+		t.Error("BeforeCreate error was expected for commands of failed transaction, but: ", err)
+	}
+	if err := tx.Commit(); err != d.createErr || c.getCurrentTransaction() != nil {
+		t.Error("BeforeCreate error was expected for transaction commit, but: ", err)
+	}
+}
+
+func TestTransCallbacks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	calls := []string{}
+	tx := c.BeginTransaction()
+	tx.OnCommit(func() error {
+		calls = append(calls, "commit")
+		return nil
+	})
+	tx.AfterCommit(func() { calls = append(calls, "after") })
+	tx.OnRollback(func() { calls = append(calls, "rollback") })
+	inner := c.BeginTransaction()
+	inner.AfterCommit(func() { calls = append(calls, "inner after") })
+	inner.Commit()
+	inner = c.BeginTransaction()
+	inner.OnRollback(func() { calls = append(calls, "inner rollback") })
+	inner.Rollback()
+	c.Set("test1", "ok")
+
+	d.EXPECT().Set("test1", "ok").Return(nil)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+	if len(calls) != 4 || calls[0] != "inner rollback" || calls[1] != "commit" || calls[2] != "after" || calls[3] != "inner after" {
+		t.Error("Callbacks were called incorrectly: ", calls)
+	}
+
+	tx = c.BeginTransaction()
+	c.Set("test2", "ok")
+	tx.OnCommit(func() error { return errors.New("test") })
+	if err := tx.Commit(); err == nil || err.Error() != "test" {
+		t.Error("OnCommit error was expected for transaction commit, but: ", err)
+	}
+	if c.getCurrentTransaction() != tx {
+		t.Error("Transaction was expected to stay active after failed OnCommit")
+	}
+}
+
+func TestTransOnCommitCommitting(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "ok")
+	tx.OnCommit(func() error {
+		if v, err := c.Get("test1"); err != nil || v != "ok" {
+			t.Error("Reads were expected while committing, but: ", v, err)
+		}
+		if err := c.Set("test2", "ok"); err != ErrTransactionCommitting {
+			t.Error("ErrTransactionCommitting was expected for write, but: ", err)
+		}
+		if err := tx.Del("test1"); err != ErrTransactionCommitting {
+			t.Error("ErrTransactionCommitting was expected for write, but: ", err)
+		}
+		if err := tx.Commit(); err != ErrTransactionCommitting {
+			t.Error("ErrTransactionCommitting was expected for commit, but: ", err)
+		}
+		if err := tx.Rollback(); err != ErrTransactionCommitting {
+			t.Error("ErrTransactionCommitting was expected for rollback, but: ", err)
+		}
+		return nil
+	})
+
+	d.EXPECT().Set("test1", "ok").Return(nil)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+}
+
+func TestTransDeferredCounters(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), DeferredCounters())
+	c.shard("counter").keys["counter"] = "5"
+
+	tx := c.BeginTransaction()
+	if v, err := c.Incr("counter", 2); err != nil || v != "7" {
+		t.Error("Increased value was expected to be computed locally, but: ", v, err)
+	}
+	if v, _ := c.Decr("counter", 10); v != "-3" {
+		t.Error("Decreased value was expected to be computed locally, but: ", v)
+	}
+	d.EXPECT().HGet("hash", "k1").Return("1.5", nil)
+	d.EXPECT().HTTL("hash", "k1").Return(map[string]time.Duration{"k1": -1}, nil)
+	if v, err := c.HIncr("hash", "k1", 0.25); err != nil || v != "1.75" {
+		t.Error("Increased hash value was expected to be computed locally, but: ", v, err)
+	}
+	if c.shard("counter").keys["counter"] != "5" {
+		t.Error("Memory should not be changed before commit")
+	}
+
+	gomock.InOrder(
+		d.EXPECT().Incr("counter", 2).Return("7", nil),
+		d.EXPECT().Decr("counter", 10).Return("-3", nil),
+		d.EXPECT().HIncr("hash", "k1", 0.25).Return("1.75", nil),
+	)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+	if _, ok := c.shard("counter").keys["counter"]; ok {
+		t.Error("Counter computed locally should be dropped from memory after commit")
+	}
+
+	d.EXPECT().HGet("hash2", "k1").Return("", driver.ErrValueNil)
+	tx = c.BeginTransaction()
+	if v, _ := c.HDecr("hash2", "k1", 1); v != "-1" {
+		t.Error("Missing hash key was expected to count as zero, but: ", v)
+	}
+	// deferred counter is not compensated
+	if err := tx.Rollback(); err != nil {
+		t.Error("No error was expected for transaction rollback, but: ", err)
+	}
+}
+
+func TestTransCommitCompensate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), OnCommitError(CompensateOnError))
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "new")
+	c.Del("test2")
+	c.HSet("hash", "k1", "v")
+	c.Set("test3", "new")
+
+	gomock.InOrder(
+		// before-images
+		d.EXPECT().Get("test1").Return("old", nil),
+		d.EXPECT().TTL("test1").Return(10*time.Second, nil),
+		d.EXPECT().Get("test2").Return("", errors.New("WRONGTYPE")),
+		d.EXPECT().HGetAll("test2").Return(map[string]string{"k1": "v1"}, nil),
+		d.EXPECT().HTTL("test2", "k1").Return(map[string]time.Duration{"k1": 1500 * time.Millisecond}, nil),
+		d.EXPECT().TTL("test2").Return(time.Duration(-1), nil),
+		d.EXPECT().HGetAll("hash").Return(map[string]string{}, nil),
+		d.EXPECT().Get("test3").Return("", driver.ErrValueNil),
+		// commit stopped at HSet
+		d.EXPECT().Set("test1", "new").Return(nil),
+		d.EXPECT().Del("test2").Return(nil),
+		d.EXPECT().HSet("hash", "k1", "v").Return(errors.New("test")),
+		// restored in reverse order
+		d.EXPECT().Del("test2").Return(nil),
+		d.EXPECT().HMSet("test2", map[string]interface{}{"k1": "v1"}).Return(nil),
+		d.EXPECT().HPExpire("test2", int64(1500), "k1").Return(nil),
+		d.EXPECT().Del("test1").Return(nil),
+		d.EXPECT().Set("test1", "old").Return(nil),
+		d.EXPECT().PExpire("test1", int64(10000)).Return(nil),
+	)
+	err := tx.Commit()
+	ce, ok := err.(*CommitError)
+	if !ok {
+		t.Fatal("CommitError was expected for transaction commit, but: ", err)
+	}
+	if !ce.Compensated || len(ce.Failed) != 2 || ce.Failed[1].Err != ErrNotApplied {
+		t.Error("Applied commands were expected to be compensated, but: ", ce)
+	}
+	if len(memory(c).keys) > 0 || len(memory(c).delKeys) > 0 {
+		t.Error("Memory should not keep writes of compensated transaction")
+	}
+}
+
+func TestTransCommitCompensateError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), OnCommitError(CompensateOnError))
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "new")
+	c.Set("test2", "new")
+
+	gomock.InOrder(
+		d.EXPECT().Get("test1").Return("", driver.ErrValueNil),
+		d.EXPECT().Get("test2").Return("", driver.ErrValueNil),
+		d.EXPECT().Set("test1", "new").Return(nil),
+		d.EXPECT().Set("test2", "new").Return(errors.New("test")),
+		d.EXPECT().Del("test1").Return(errors.New("down")),
+	)
+	ce, ok := tx.Commit().(*CommitError)
+	if !ok || ce.Compensated || len(ce.CompensateErrors) != 1 {
+		t.Fatal("CommitError with failed compensation was expected, but: ", ce)
+	}
+	if ce.Error() != "cache: commit failed (Set test2: test), compensation failed (down)" {
+		t.Error("CommitError message incorrect: ", ce.Error())
+	}
+}
+
+func TestTransClosed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().Set("test1", "1").Return(nil)
+	tx := c.NewTransaction()
+	tx.Set("test1", "1")
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for commit, but: ", err)
+	}
+	if err := tx.Set("test1", "2"); err != ErrTransactionClosed {
+		t.Error("ErrTransactionClosed was expected for set after commit, but: ", err)
+	}
+	if _, err := tx.Get("test1"); err != ErrTransactionClosed {
+		t.Error("ErrTransactionClosed was expected for get after commit, but: ", err)
+	}
+	if _, err := tx.TTL("test1"); err != ErrTransactionClosed {
+		t.Error("ErrTransactionClosed was expected for TTL after commit, but: ", err)
+	}
+	if tx.Watch("test1") != ErrTransactionClosed || tx.Lock("test1") != ErrTransactionClosed {
+		t.Error("ErrTransactionClosed was expected for watch and lock after commit")
+	}
+	if tx.Commit() != nil || tx.Rollback() != nil || len(tx.Pending()) != 0 {
+		t.Error("Commit and rollback of closed transaction were expected to do nothing")
+	}
+}
+
+func TestTransTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction(Timeout(time.Hour))
+	sp := c.BeginTransaction(Deadline(time.Now().Add(-time.Second)))
+	c.Set("test1", "tx")
+	if !tx.Active() || sp.Active() {
+		t.Error("Expired savepoint was expected to be rolled back alone")
+	}
+	if tx.Age() <= 0 || tx.Age() > time.Minute {
+		t.Error("Age of transaction incorrect: ", tx.Age())
+	}
+	if err := sp.Commit(); err != ErrTransactionExpired {
+		t.Error("ErrTransactionExpired was expected for commit of expired savepoint, but: ", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tx = c.NewTransaction(WithContext(ctx))
+	d.EXPECT().Incr("counter", 1).Return("1", nil)
+	tx.Incr("counter", 1)
+	d.EXPECT().Decr("counter", 1).Return("0", nil)
+	cancel()
+	if err := tx.Commit(); err != ErrTransactionExpired || tx.Active() {
+		t.Error("ErrTransactionExpired was expected for commit of cancelled transaction, but: ", err)
+	}
+}
+
+func TestTransTimeoutUnused(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.NewTransaction(Timeout(10 * time.Millisecond))
+	if err := tx.Set("test1", "tx"); err != nil {
+		t.Error("No error was expected for set before expiration, but: ", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	tx2 := c.NewTransaction(WithContext(ctx))
+	cancel()
+	for i := 0; i < 100 && (tx.Active() || tx2.Active()); i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if tx.Active() || tx2.Active() {
+		t.Error("Expired transactions were expected to be rolled back without being used")
+	}
+	if err := tx.Set("test1", "tx"); err != ErrTransactionExpired {
+		t.Error("ErrTransactionExpired was expected for set after expiration, but: ", err)
+	}
+	if _, err := tx2.Get("test1"); err != ErrTransactionExpired {
+		t.Error("ErrTransactionExpired was expected for get after cancel, but: ", err)
+	}
+	if err := tx.Commit(); err != ErrTransactionExpired {
+		t.Error("ErrTransactionExpired was expected for commit, but: ", err)
+	}
+}
+
+func TestTransUnlockedRead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	reading, read := make(chan bool), make(chan bool)
+	d.EXPECT().Get("test1").DoAndReturn(func(key string) (string, error) {
+		close(reading)
+		<-read
+		return "v1", nil
+	})
+	done := make(chan string)
+	go func() {
+		v, _ := c.Get("test1")
+		done <- v
+	}()
+	<-reading
+	if err := c.Set("test2", "v2"); err != nil { // not blocked by the read
+		t.Error("No error was expected to set while reading, but: ", err)
+	}
+	close(read)
+	if v := <-done; v != "v1" {
+		t.Error("Value was expected to be read from driver, but: ", v)
+	}
+
+	d.EXPECT().Set("test2", "v2").Return(nil)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+}
+
+func TestTransExpiredCurrent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction(Timeout(20 * time.Millisecond))
+	c.Set("test1", "tx")
+	time.Sleep(30 * time.Millisecond)
+
+	d.EXPECT().Set("test1", "direct").Return(nil)
+	if err := c.Set("test1", "direct"); err != nil {
+		t.Error("No error was expected for set after expiration, but: ", err)
+	}
+	if tx.Active() || c.shard("test1").keys["test1"] != "direct" {
+		t.Error("Expired transaction was expected to be rolled back and writes applied directly")
+	}
+	if err := tx.Commit(); err != ErrTransactionExpired {
+		t.Error("ErrTransactionExpired was expected for commit, but: ", err)
+	}
+}
+
+func TestTransLeakWarning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	leaks := []Transaction{}
+	var stack []byte
+	c := newCacheImpl(Driver(d), LeakWarning(time.Millisecond, func(tx Transaction, s []byte) {
+		leaks = append(leaks, tx)
+		stack = s
+	}))
+
+	tx := c.BeginTransaction()
+	done := c.NewTransaction()
+	done.Rollback()
+	c.Set("test1", "tx")
+	if len(leaks) != 0 {
+		t.Error("No leak was expected before threshold")
+	}
+	time.Sleep(2 * time.Millisecond)
+	c.Get("test1")
+	c.Get("test1")
+	if len(leaks) != 1 || leaks[0] != tx {
+		t.Error("Leak of transaction was expected to be reported once, but: ", leaks)
+	}
+	if !strings.Contains(string(stack), "TestTransLeakWarning") {
+		t.Error("Stack trace of transaction start was expected, but: ", string(stack))
+	}
+}
+
+func TestTransLeakCheckRate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	leaks := 0
+	c := newCacheImpl(Driver(d), LeakWarning(time.Hour, func(tx Transaction, s []byte) {
+		leaks++
+	}))
+
+	tx := c.NewTransaction()
+	c.NewTransaction() // checked once for both
+	tx.(*transImpl).started = time.Now().Add(-2 * time.Hour)
+	c.NewTransaction()
+	if leaks != 0 {
+		t.Error("Leaks were expected to be checked at most once per half threshold, but: ", leaks)
+	}
+	c.leakCheck = time.Time{} // half threshold elapsed
+	c.NewTransaction()
+	if leaks != 1 {
+		t.Error("Leak was expected to be reported once checked again, but: ", leaks)
+	}
+}
+
+func TestTransCoalesce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), Coalesce(), DeferredCounters())
+	c.shard("counter").keys["counter"] = "1"
+
+	tx := c.BeginTransaction()
+	c.Set("a", 1)
+	c.HSet("h", "f1", 1)
+	c.Set("a", 2)
+	c.Incr("counter", 1)
+	c.Expire("b", 10)
+	c.Set("b", 1)
+	c.HMSet("h", map[string]interface{}{"f2": 2, "f1": 3})
+	c.MSet(map[string]interface{}{"c": 3, "counter": 5})
+	c.Publish("channel", "msg")
+	c.HSet("h", "f3", 3)
+	c.Del("d")
+	c.Set("d", "x")
+
+	gomock.InOrder(
+		d.EXPECT().MSet(map[string]interface{}{"a": 2, "b": 1, "c": 3, "counter": 5}).Return(nil),
+		d.EXPECT().HMSet("h", map[string]interface{}{"f1": 3, "f2": 2}).Return(nil),
+		d.EXPECT().Publish("channel", "msg").Return(int64(1), nil),
+		d.EXPECT().Set("d", "x").Return(nil),
+		d.EXPECT().HSet("h", "f3", 3).Return(nil),
+	)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+	if c.shard("a").keys["a"] != "2" || c.shard("h").hsets["h"]["f1"] != "3" || c.shard("d").keys["d"] != "x" {
+		t.Error("Memory was expected to hold the last writes")
+	}
+}
+
+func TestTransCoalesceMixedKinds(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), Coalesce())
+
+	tx := c.BeginTransaction()
+	c.HSet("k", "f1", 1)
+	c.Set("a", 1)
+	c.Set("k", "v")
+	c.HSet("k", "f2", 2)
+
+	gomock.InOrder(
+		d.EXPECT().Set("a", 1).Return(nil),
+		d.EXPECT().Set("k", "v").Return(nil),
+		d.EXPECT().HSet("k", "f2", 2).Return(errors.New("WRONGTYPE")),
+	)
+	if _, ok := tx.Commit().(*CommitError); !ok {
+		t.Error("CommitError was expected for hash set of string key")
+	}
+}
+
+func TestTransCoalesceMarker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), Coalesce(), TransactionMarkers(time.Hour), OnCommitError(ContinueOnError))
+
+	tx := c.NewTransaction(WithID("id1"))
+	tx.Del("test2")
+	tx.Set("test1", 1) // not merged with the marker
+	gomock.InOrder(
+		d.EXPECT().Get("tx:id1").Return("", driver.ErrValueNil),
+		d.EXPECT().Del("test2").Return(nil),
+		d.EXPECT().Set("test1", 1).Return(errors.New("test")),
+		d.EXPECT().Del("tx:id1").Return(nil),
+	)
+	if _, ok := tx.Commit().(*CommitError); !ok {
+		t.Error("CommitError was expected without writing the marker")
+	}
+}
+
+func TestTransRepeatableRead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+	c.shard("test1").keys["test1"] = "memory"
+
+	tx := c.BeginTransaction(Isolation(RepeatableRead))
+	d.EXPECT().Get("test1").Return("v1", nil)
+	d.EXPECT().HGet("hash", "k1").Return("1", nil)
+	d.EXPECT().HGetAll("hash").Return(map[string]string{"k1": "2", "k2": "x"}, nil)
+	if v, _ := c.Get("test1"); v != "v1" {
+		t.Error("Value was expected to be read from driver, but: ", v)
+	}
+	sp := c.BeginTransaction()
+	if v, _ := c.Get("test1"); v != "v1" {
+		t.Error("Value was expected to be pinned in savepoint, but: ", v)
+	}
+	sp.Commit()
+	c.HGet("hash", "k1")
+	if m, _ := c.HGetAll("hash"); m["k1"] != "1" || m["k2"] != "x" {
+		t.Error("Hash keys were expected to keep the first read values, but: ", m)
+	}
+	if ok, _ := c.HExists("hash", "k3"); ok {
+		t.Error("Hash key missing from pinned hash should not exist")
+	}
+	c.Set("test2", "v2")
+
+	gomock.InOrder(
+		d.EXPECT().Get("test1").Return("v1", nil),
+		d.EXPECT().HGetAll("hash").Return(map[string]string{"k1": "1", "k2": "x"}, nil),
+		d.EXPECT().Set("test2", "v2").Return(nil),
+	)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+
+	tx = c.BeginTransaction(Isolation(RepeatableRead))
+	d.EXPECT().Get("test1").Return("v1", nil)
+	c.Get("test1")
+	c.Set("test2", "v3")
+	d.EXPECT().Get("test1").Return("changed", nil)
+	if err := tx.Commit(); err != ErrConflict {
+		t.Error("ErrConflict was expected for changed read, but: ", err)
+	}
+}
+
+func TestTransRepeatableReadOnlyConflict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := &watchDriver{MockDriver: dmock.NewMockDriver(ctrl), conflicts: 1}
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().Get("test1").Return("v1", nil)
+	tx := c.BeginTransaction(Isolation(RepeatableRead))
+	if v, _ := c.Get("test1"); v != "v1" {
+		t.Error("Value was expected to be read from driver, but: ", v)
+	}
+	if err := tx.Commit(); err != ErrConflict {
+		t.Error("ErrConflict was expected for read-only transaction whose pinned key changed, but: ", err)
+	}
+	if len(d.watched) != 1 || d.closed != 1 {
+		t.Error("Pinned key was expected to be watched in session: ", d.watched, d.closed)
+	}
+}
+
+func TestTransLock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction(LockLease(time.Second))
+	var token interface{}
+	gomock.InOrder(
+		d.EXPECT().Eval(lockScript, "lock:item1", gomock.Any(), int64(1000)).DoAndReturn(
+			func(s *driver.Script, keysAndArgs ...interface{}) (interface{}, error) {
+				token = keysAndArgs[1]
+				return int64(1), nil
+			}),
+		d.EXPECT().Eval(lockScript, "lock:item2", gomock.Any(), int64(1000)).Return(int64(1), nil),
+	)
+	if err := tx.Lock("item2", "item1"); err != nil {
+		t.Error("No error was expected to lock, but: ", err)
+	}
+	sp := c.BeginTransaction()
+	if err := sp.Lock("item1"); err != nil {
+		t.Error("Lock held by outer transaction was expected to be reused, but: ", err)
+	}
+	sp.Rollback()
+	c.Set("item1", 1)
+
+	gomock.InOrder(
+		d.EXPECT().Eval(renewScript, "lock:item1", token, int64(1000)).Return(int64(1), nil),
+		d.EXPECT().Eval(renewScript, "lock:item2", token, int64(1000)).Return(int64(1), nil),
+		d.EXPECT().Set("item1", 1).Return(nil),
+		d.EXPECT().Eval(unlockScript, "lock:item1", token).Return(int64(1), nil),
+		d.EXPECT().Eval(unlockScript, "lock:item2", token).Return(int64(1), nil),
+	)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+}
+
+func TestTransLockFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	// fail fast, locks taken by the call are released
+	tx := c.BeginTransaction()
+	gomock.InOrder(
+		d.EXPECT().Eval(lockScript, "lock:item1", gomock.Any(), int64(30000)).Return(int64(1), nil),
+		d.EXPECT().Eval(lockScript, "lock:item2", gomock.Any(), int64(30000)).Return(int64(0), nil),
+		d.EXPECT().Eval(unlockScript, "lock:item1", gomock.Any()).Return(int64(1), nil),
+	)
+	if err := tx.Lock("item1", "item2"); err != ErrLocked {
+		t.Error("ErrLocked was expected to lock, but: ", err)
+	}
+	tx.Rollback()
+
+	// wait for lock, released when the transaction expires
+	released := make(chan bool)
+	d.EXPECT().Eval(unlockScript, "lock:item1", gomock.Any()).DoAndReturn(
+		func(script *driver.Script, keysAndArgs ...interface{}) (interface{}, error) {
+			close(released)
+			return int64(1), nil
+		})
+	tx = c.BeginTransaction(LockWait(time.Second), Timeout(50*time.Millisecond))
+	gomock.InOrder(
+		d.EXPECT().Eval(lockScript, "lock:item1", gomock.Any(), int64(30000)).Return(int64(0), nil),
+		d.EXPECT().Eval(lockScript, "lock:item1", gomock.Any(), int64(30000)).Return(int64(1), nil),
+	)
+	if err := tx.Lock("item1"); err != nil {
+		t.Error("No error was expected to lock after waiting, but: ", err)
+	}
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Error("Lock was expected to be released when the transaction expires")
+	}
+	if tx.Active() {
+		t.Error("Expired transaction was expected to be rolled back")
+	}
+}
+
+func TestTransLockLeaseLost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.BeginTransaction()
+	d.EXPECT().Eval(lockScript, "lock:item1", gomock.Any(), int64(30000)).Return(int64(1), nil)
+	tx.Lock("item1")
+	c.Set("item1", 1)
+	rolledBack := false
+	tx.OnRollback(func() { rolledBack = true })
+
+	gomock.InOrder(
+		d.EXPECT().Eval(renewScript, "lock:item1", gomock.Any(), int64(30000)).Return(int64(0), nil),
+		d.EXPECT().Eval(unlockScript, "lock:item1", gomock.Any()).Return(int64(0), nil),
+	)
+	if err := tx.Commit(); err != ErrLocked {
+		t.Error("ErrLocked was expected for commit after lease expired, but: ", err)
+	}
+	if tx.Active() || !rolledBack {
+		t.Error("Transaction was expected to be rolled back")
+	}
+}
+
+func TestTransLockWaitUnlocked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.NewTransaction(LockWait(time.Second))
+	waiting := make(chan bool)
+	gomock.InOrder(
+		d.EXPECT().Eval(lockScript, "lock:item1", gomock.Any(), int64(30000)).DoAndReturn(
+			func(s *driver.Script, keysAndArgs ...interface{}) (interface{}, error) {
+				close(waiting)
+				return int64(0), nil
+			}),
+		d.EXPECT().Eval(lockScript, "lock:item1", gomock.Any(), int64(30000)).Return(int64(0), nil).AnyTimes(),
+	)
+	done := make(chan error)
+	go func() { done <- tx.Lock("item1") }()
+	<-waiting
+	if err := tx.Rollback(); err != nil { // not blocked by the wait
+		t.Error("No error was expected to roll back, but: ", err)
+	}
+	if err := <-done; err != ErrTransactionClosed {
+		t.Error("ErrTransactionClosed was expected to lock once rolled back, but: ", err)
+	}
+}
+
+func TestTransMarker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), TransactionMarkers(time.Hour))
+
+	if id := c.NewTransaction().ID(); len(id) != 32 {
+		t.Error("Generated ID was expected, but: ", id)
+	}
+	tx := c.BeginTransaction(WithID("id1"))
+	c.Set("test1", 1)
+	if sp := c.BeginTransaction(); sp.ID() != "id1" {
+		t.Error("Savepoint was expected to share the ID, but: ", sp.ID())
+	} else {
+		sp.Commit()
+	}
+	gomock.InOrder(
+		d.EXPECT().Get("tx:id1").Return("", driver.ErrValueNil),
+		d.EXPECT().Set("test1", 1).Return(nil),
+		d.EXPECT().Set("tx:id1", "1").Return(nil),
+		d.EXPECT().PExpire("tx:id1", int64(3600000)).Return(nil),
+	)
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+
+	// retry with the same ID
+	tx = c.BeginTransaction(WithID("id1"))
+	c.Set("test1", 1)
+	d.EXPECT().Incr("counter", 1).Return("1", nil)
+	c.Incr("counter", 1)
+	committed := false
+	tx.AfterCommit(func() { committed = true })
+	gomock.InOrder(
+		d.EXPECT().Get("tx:id1").Return("1", nil),
+		d.EXPECT().Decr("counter", 1).Return("0", nil),
+	)
+	if err := tx.Commit(); err != nil || !committed || tx.Active() {
+		t.Error("Commit of applied transaction was expected to do nothing, but: ", err)
+	}
+
+	d.EXPECT().Get("tx:id1").Return("1", nil)
+	d.EXPECT().Get("tx:id2").Return("", driver.ErrValueNil)
+	if ok, err := c.TransactionApplied("id1"); !ok || err != nil {
+		t.Error("Transaction id1 was expected to be applied: ", err)
+	}
+	if ok, _ := c.TransactionApplied("id2"); ok {
+		t.Error("Transaction id2 should not be applied")
+	}
+}
+
+func TestTransMarkerFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), TransactionMarkers(time.Hour), OnCommitError(ContinueOnError))
+
+	tx := c.NewTransaction(WithID("id1"))
+	tx.Set("test1", 1)
+	tx.Set("test2", 2)
+	gomock.InOrder(
+		d.EXPECT().Get("tx:id1").Return("", driver.ErrValueNil),
+		d.EXPECT().Set("test1", 1).Return(errors.New("test")),
+		d.EXPECT().Set("test2", 2).Return(nil),
+		d.EXPECT().Del("tx:id1").Return(nil),
+	)
+	if _, ok := tx.Commit().(*CommitError); !ok {
+		t.Error("CommitError was expected without writing the marker")
+	}
+}
@@ -0,0 +1,148 @@
+package cache
+
+// coalesce optimize queued writes before commit, with the same result as applying them one by one.
+// Writes superseded by a later Set, MSet or Del of the same key are dropped, then runs of Set,
+// MSet, HSet and HMSet are merged into one MSet and one HMSet per hash. Streams and messages
+// are kept in order. Counters applied immediately are kept first, they are not applied again.
+// Commands of the transaction marker are kept last as is, so that they are skipped if a write fails.
+func (t *transImpl) coalesce() {
+	cmds := []*command{}
+	writes := []*command{}
+	markers := []*command{}
+	for _, cmd := range t.cmds {
+		if cmd.marker {
+			markers = append(markers, cmd)
+		} else if cmd.isWrite() {
+			writes = append(writes, cmd)
+		} else {
+			cmds = append(cmds, cmd)
+		}
+	}
+	cmds = append(cmds, mergeSets(dropSuperseded(writes))...)
+	t.cmds = append(cmds, markers...)
+}
+
+// dropSuperseded drop writes of keys overwritten later by Set, MSet or Del
+func dropSuperseded(cmds []*command) []*command {
+	overwritten := map[string]bool{}
+	kept := []*command{}
+	for i := len(cmds) - 1; i >= 0; i-- {
+		cmd := cmds[i]
+		switch cmd.t {
+		case typeXAdd, typePublish:
+		case typeMSet:
+			kvs := map[string]interface{}{}
+			all := cmd.args[0].(map[string]interface{})
+			for k, v := range all {
+				if !overwritten[k] {
+					kvs[k] = v
+				}
+			}
+			if len(kvs) == 0 {
+				continue
+			}
+			for k := range kvs {
+				overwritten[k] = true
+			}
+			if len(kvs) < len(all) {
+				cmd = &command{t: typeMSet, args: []interface{}{kvs}}
+			}
+		default:
+			key := cmd.args[0].(string)
+			if overwritten[key] {
+				continue
+			}
+			if cmd.t == typeSet || cmd.t == typeDel {
+				overwritten[key] = true
+			}
+		}
+		kept = append(kept, cmd)
+	}
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	return kept
+}
+
+// mergeSets merge runs of Set, MSet, HSet and HMSet
+func mergeSets(cmds []*command) []*command {
+	merged := []*command{}
+	for i := 0; i < len(cmds); {
+		j := i
+		for j < len(cmds) && isSet(cmds[j]) {
+			j++
+		}
+		if j == i {
+			merged = append(merged, cmds[i])
+			i++
+			continue
+		}
+		merged = append(merged, mergeRun(cmds[i:j])...)
+		i = j
+	}
+	return merged
+}
+
+// isSet check if command sets keys or hash keys
+func isSet(cmd *command) bool {
+	switch cmd.t {
+	case typeSet, typeMSet, typeHSet, typeHMSet:
+		return true
+	}
+	return false
+}
+
+// mergeRun merge sets of keys into one MSet and sets of hash keys into one HMSet per hash, in
+// order of first appearance. The run is kept as is if a key is set both as string and as hash.
+func mergeRun(run []*command) []*command {
+	var strs []*command
+	hashes := []string{}
+	hcmds := map[string][]*command{}
+	kvs := map[string]interface{}{}
+	hkvs := map[string]map[string]interface{}{}
+	for _, cmd := range run {
+		switch cmd.t {
+		case typeSet:
+			strs = append(strs, cmd)
+			kvs[cmd.args[0].(string)] = cmd.args[1]
+		case typeMSet:
+			strs = append(strs, cmd)
+			for k, v := range cmd.args[0].(map[string]interface{}) {
+				kvs[k] = v
+			}
+		default:
+			key := cmd.args[0].(string)
+			if _, ok := hkvs[key]; !ok {
+				hashes = append(hashes, key)
+				hkvs[key] = map[string]interface{}{}
+			}
+			hcmds[key] = append(hcmds[key], cmd)
+			if cmd.t == typeHSet {
+				hkvs[key][cmd.args[1].(string)] = cmd.args[2]
+			} else {
+				for hk, v := range cmd.args[1].(map[string]interface{}) {
+					hkvs[key][hk] = v
+				}
+			}
+		}
+	}
+	for k := range kvs {
+		if _, ok := hkvs[k]; ok {
+			return run
+		}
+	}
+	merged := []*command{}
+	if len(strs) == 1 {
+		merged = append(merged, strs[0])
+	} else if len(strs) > 1 {
+		merged = append(merged, &command{t: typeMSet, args: []interface{}{kvs}})
+	}
+	for _, key := range hashes {
+		if len(hcmds[key]) == 1 {
+			merged = append(merged, hcmds[key][0])
+		} else {
+			merged = append(merged, &command{t: typeHMSet, args: []interface{}{key, hkvs[key]}})
+		}
+	}
+	return merged
+}
@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Command write command of transaction
+type Command struct {
+	Op     string      `json:"op"`               // operation, e.g. "Set", "HMSet"
+	Key    string      `json:"key,omitempty"`    // key or channel, empty for MSet
+	Field  string      `json:"field,omitempty"`  // hash key, or message id for XAdd
+	Fields []string    `json:"fields,omitempty"` // hash keys of HExpire, HPExpire and HPersist
+	Value  interface{} `json:"value,omitempty"`  // value, delta, expiration or message
+
+	// types of values not kept by JSON, "bytes" for []byte and "float" for floats
+	Type  string            `json:"type,omitempty"`  // type of value
+	Types map[string]string `json:"types,omitempty"` // types of values of map value, by key
+}
+
+// type tags of values not kept by JSON
+const (
+	typeTagBytes = "bytes"
+	typeTagFloat = "float"
+)
+
+// Pending get write commands queued for commit, counters applied immediately are not included
+func (t *transImpl) Pending() []Command {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pending()
+}
+
+func (t *transImpl) pending() []Command {
+	cmds := []Command{}
+	for _, cmd := range t.cmds {
+		if cmd.isWrite() {
+			cmds = append(cmds, exportCommand(cmd))
+		}
+	}
+	return cmds
+}
+
+// ExportCommands encode commands to JSON
+func ExportCommands(cmds []Command) ([]byte, error) {
+	return json.Marshal(cmds)
+}
+
+// ImportCommands decode commands from JSON, values tagged by Type and Types get their types back,
+// other numbers are decoded as int64 if integral, float64 otherwise
+func ImportCommands(data []byte) ([]Command, error) {
+	cmds := []Command{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&cmds); err != nil {
+		return nil, err
+	}
+	for i := range cmds {
+		v, err := cmds[i].value()
+		if err != nil {
+			return nil, fmt.Errorf("cache: invalid command %s %s (%s)", cmds[i].Op, cmds[i].Key, err)
+		}
+		cmds[i].Value = v
+	}
+	return cmds, nil
+}
+
+// Replay run commands with c in order, inside the current transaction if c is a Cache,
+// stops at the first failure
+func Replay(c Commands, cmds []Command) error {
+	for _, ec := range cmds {
+		cmd, err := importCommand(ec)
+		if err != nil {
+			return err
+		}
+		if err := apply(c, cmd); err != nil {
+			return newCommandError(cmd, err)
+		}
+	}
+	return nil
+}
+
+// exportCommand convert command to Command
+func exportCommand(cmd *command) Command {
+	c := Command{Op: commandOps[cmd.t]}
+	args := cmd.args
+	switch cmd.t {
+	case typeMSet:
+		c.Value = args[0]
+	case typeDel:
+		c.Key = args[0].(string)
+	case typeHDel:
+		c.Key, c.Field = args[0].(string), args[1].(string)
+	case typeHSet, typeHIncr, typeHDecr, typeXAdd:
+		c.Key, c.Field, c.Value = args[0].(string), args[1].(string), args[2]
+	case typeHExpire, typeHPExpire:
+		c.Key, c.Value, c.Fields = args[0].(string), args[1], args[2].([]string)
+	case typeHPersist:
+		c.Key, c.Fields = args[0].(string), args[1].([]string)
+	default:
+		c.Key, c.Value = args[0].(string), args[1]
+	}
+	c.Type = valueType(c.Value)
+	if m, ok := c.Value.(map[string]interface{}); ok {
+		for k, v := range m {
+			if typ := valueType(v); typ != "" {
+				if c.Types == nil {
+					c.Types = map[string]string{}
+				}
+				c.Types[k] = typ
+			}
+		}
+	}
+	return c
+}
+
+// valueType get type tag of value whose type is not kept by JSON, empty otherwise
+func valueType(v interface{}) string {
+	switch v.(type) {
+	case []byte:
+		return typeTagBytes
+	case float32, float64:
+		return typeTagFloat
+	}
+	return ""
+}
+
+// value get value of command, numbers decoded from JSON are converted to int64 or float64
+// and values tagged by Type and Types get their types back
+func (c Command) value() (interface{}, error) {
+	v, err := typed(normalize(c.Value), c.Type)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		for k, typ := range c.Types {
+			if m[k], err = typed(m[k], typ); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// typed convert value decoded from JSON to type tag typ
+func typed(v interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case "":
+		return v, nil
+	case typeTagBytes:
+		switch x := v.(type) {
+		case []byte:
+			return x, nil
+		case string:
+			return base64.StdEncoding.DecodeString(x)
+		}
+	case typeTagFloat:
+		switch x := v.(type) {
+		case float64, float32:
+			return x, nil
+		case int64:
+			return float64(x), nil
+		}
+	}
+	return nil, fmt.Errorf("not a %s %v", typ, v)
+}
+
+// importCommand convert Command to command, values decoded from JSON are converted
+// to the types of the command. Counters are applied on commit.
+func importCommand(c Command) (*command, error) {
+	t := 0
+	for k, op := range commandOps {
+		if op == c.Op {
+			t = k
+		}
+	}
+	if t == 0 || (c.Key == "" && t != typeMSet) {
+		return nil, fmt.Errorf("cache: invalid command %s %s", c.Op, c.Key)
+	}
+	v, err := c.value()
+	if err != nil {
+		return nil, fmt.Errorf("cache: invalid command %s %s (%s)", c.Op, c.Key, err)
+	}
+	var args []interface{}
+	switch t {
+	case typeMSet:
+		var kvs map[string]interface{}
+		kvs, err = toMap(v)
+		args = []interface{}{kvs}
+	case typeHMSet:
+		var kvs map[string]interface{}
+		kvs, err = toMap(v)
+		args = []interface{}{c.Key, kvs}
+	case typeXAdd:
+		var kvs map[string]interface{}
+		kvs, err = toMap(v)
+		args = []interface{}{c.Key, c.Field, kvs}
+	case typeDel:
+		args = []interface{}{c.Key}
+	case typeHDel:
+		args = []interface{}{c.Key, c.Field}
+	case typeHSet, typeHIncr, typeHDecr:
+		args = []interface{}{c.Key, c.Field, v}
+	case typeHExpire, typeHPExpire:
+		var n int64
+		n, err = toInt64(v)
+		args = []interface{}{c.Key, n, c.Fields}
+	case typeHPersist:
+		args = []interface{}{c.Key, c.Fields}
+	case typeExpire, typePExpire:
+		var n int64
+		n, err = toInt64(v)
+		args = []interface{}{c.Key, n}
+	case typeExpireDuration:
+		var n int64
+		n, err = toInt64(v)
+		args = []interface{}{c.Key, time.Duration(n)}
+	case typeExpireAt:
+		tm, ok := v.(time.Time)
+		if s, o := v.(string); o {
+			tm, err = time.Parse(time.RFC3339Nano, s)
+		} else if !ok {
+			err = fmt.Errorf("not a time %v", v)
+		}
+		args = []interface{}{c.Key, tm}
+	default:
+		args = []interface{}{c.Key, v}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cache: invalid command %s %s (%s)", c.Op, c.Key, err)
+	}
+	cmd := &command{t: t, args: args}
+	switch t {
+	case typeIncr, typeDecr, typeHIncr, typeHDecr:
+		cmd.deferred = true
+	}
+	return cmd, nil
+}
+
+// normalize convert JSON numbers to int64 or float64
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return n
+		}
+		f, _ := x.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = normalize(e)
+		}
+	}
+	return v
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch x := v.(type) {
+	case int64:
+		return x, nil
+	case int:
+		return int64(x), nil
+	case time.Duration:
+		return int64(x), nil
+	case string:
+		return strconv.ParseInt(x, 10, 64)
+	}
+	return 0, fmt.Errorf("not an integer %v", v)
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("not a map %v", v)
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	dmock "github.com/go-lego/cache/driver/mock"
+	"github.com/golang/mock/gomock"
+)
+
+func TestTransPending(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+	tm := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "v1")
+	c.HSet("hash", "k1", 2)
+	c.HPExpire("hash", 1500, "k1")
+	d.EXPECT().Incr("counter", 1).Return("1", nil)
+	c.Incr("counter", 1)
+	c.ExpireAt("test1", tm)
+	c.Del("test2")
+	pending := tx.Pending()
+	expected := []Command{
+		{Op: "Set", Key: "test1", Value: "v1"},
+		{Op: "HSet", Key: "hash", Field: "k1", Value: 2},
+		{Op: "HPExpire", Key: "hash", Fields: []string{"k1"}, Value: int64(1500)},
+		{Op: "ExpireAt", Key: "test1", Value: tm},
+		{Op: "Del", Key: "test2"},
+	}
+	if !reflect.DeepEqual(pending, expected) {
+		t.Error("Pending commands incorrect: ", pending)
+	}
+
+	data, err := ExportCommands(pending)
+	if err != nil {
+		t.Fatal("No error was expected to export, but: ", err)
+	}
+	if string(data) != `[{"op":"Set","key":"test1","value":"v1"},{"op":"HSet","key":"hash","field":"k1","value":2},`+
+		`{"op":"HPExpire","key":"hash","fields":["k1"],"value":1500},{"op":"ExpireAt","key":"test1","value":"2020-01-01T00:00:00Z"},`+
+		`{"op":"Del","key":"test2"}]` {
+		t.Error("Exported commands incorrect: ", string(data))
+	}
+	d.EXPECT().Decr("counter", 1).Return("0", nil)
+	tx.Rollback()
+}
+
+func TestReplay(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	cmds, err := ImportCommands([]byte(`[{"op":"Set","key":"test1","value":"v1"},{"op":"HSet","key":"hash","field":"k1","value":2},` +
+		`{"op":"HPExpire","key":"hash","fields":["k1"],"value":1500},{"op":"ExpireAt","key":"test1","value":"2020-01-01T00:00:00Z"},` +
+		`{"op":"HIncr","key":"hash","field":"k2","value":0.5},{"op":"Unknown","key":"test2"}]`))
+	if err != nil {
+		t.Fatal("No error was expected to import, but: ", err)
+	}
+
+	gomock.InOrder(
+		d.EXPECT().Set("test1", "v1").Return(nil),
+		d.EXPECT().HSet("hash", "k1", int64(2)).Return(nil),
+		d.EXPECT().HPExpire("hash", int64(1500), "k1").Return(nil),
+		d.EXPECT().ExpireAt("test1", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)).Return(nil),
+		d.EXPECT().HIncr("hash", "k2", 0.5).Return("0.5", nil),
+	)
+	if err := Replay(c, cmds); err == nil {
+		t.Error("Error of unknown command was expected to replay")
+	}
+
+	tx := c.BeginTransaction()
+	Replay(c, cmds[:1])
+	if p := tx.Pending(); len(p) != 1 || p[0].Key != "test1" {
+		t.Error("Commands were expected to be replayed inside the current transaction, but: ", p)
+	}
+	tx.Rollback()
+}
+
+func TestExportImportTypes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.NewTransaction()
+	tx.Set("test1", []byte{0, 1, 255})
+	tx.HSet("hash", "k1", 2.0)
+	tx.HMSet("hash", map[string]interface{}{"k2": []byte("v"), "k3": 1.5, "k4": "s"})
+	pending := tx.Pending()
+	data, err := ExportCommands(pending)
+	if err != nil {
+		t.Fatal("No error was expected to export, but: ", err)
+	}
+	cmds, err := ImportCommands(data)
+	if err != nil || !reflect.DeepEqual(cmds, pending) {
+		t.Error("Imported commands were expected to keep value types, but: ", cmds, err)
+	}
+
+	gomock.InOrder(
+		d.EXPECT().Set("test1", []byte{0, 1, 255}).Return(nil),
+		d.EXPECT().HSet("hash", "k1", 2.0).Return(nil),
+		d.EXPECT().HMSet("hash", map[string]interface{}{"k2": []byte("v"), "k3": 1.5, "k4": "s"}).Return(nil),
+	)
+	if err := Replay(c, cmds); err != nil {
+		t.Error("No error was expected to replay, but: ", err)
+	}
+	tx.Rollback()
+}
@@ -0,0 +1,296 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// decisions of coordinator outcome
+const (
+	decisionCommit = "commit"
+	decisionAbort  = "abort"
+)
+
+// Coordinator two-phase commit of transactions of several caches. Prepare watches the keys
+// written by every transaction, runs their commit hooks and captures before-images, then
+// Commit applies every transaction or restores the ones already applied if one fails.
+// Transactions keep their locks until the outcome is decided, AfterCommit callbacks are
+// only run once every transaction is applied.
+// The outcome is recorded in log so that an interrupted commit can be resolved by Recover,
+// transactions are marked then (see TransactionMarkers) so that Recover does not apply again
+// the ones already applied.
+// Counters applied immediately are not restored, use DeferredCounters option.
+type Coordinator struct {
+	log          Journal
+	participants []*participant
+	prepared     bool
+}
+
+// participant transaction enlisted in coordinator
+type participant struct {
+	name    string
+	tx      *transImpl
+	images  []*image
+	applied bool // writes attempted, the transaction is ended by finish
+	undone  bool // nothing of the transaction was kept
+}
+
+// outcome record of coordinator commit in log
+type outcome struct {
+	Time         time.Time             `json:"time"`
+	Decision     string                `json:"decision"`
+	Participants []*outcomeParticipant `json:"participants"`
+}
+
+// outcomeParticipant record of participant in log
+type outcomeParticipant struct {
+	Name     string    `json:"name"`
+	ID       string    `json:"id,omitempty"` // transaction ID, its marker is written with the commands
+	Commands []Command `json:"commands"`
+	Images   []*image  `json:"images,omitempty"`
+	Done     bool      `json:"done"` // transaction applied
+}
+
+// NewCoordinator create coordinator recording outcomes in log, nothing is recorded if log is nil
+func NewCoordinator(log Journal) *Coordinator {
+	return &Coordinator{log: log}
+}
+
+// Enlist transaction tx of cache named name, tx must be active and not nested
+func (co *Coordinator) Enlist(name string, tx Transaction) error {
+	t, ok := tx.(*transImpl)
+	if !ok || !t.Active() || t.parent != nil || co.prepared {
+		return ErrNotEnlisted
+	}
+	co.participants = append(co.participants, &participant{name: name, tx: t})
+	return nil
+}
+
+// Prepare every transaction, they are all rolled back if one fails
+func (co *Coordinator) Prepare() error {
+	if co.prepared {
+		return nil
+	}
+	for _, p := range co.participants {
+		if err := p.prepare(co.log != nil); err != nil {
+			co.Rollback()
+			return &CoordinatorError{Participant: p.name, Err: err}
+		}
+	}
+	co.prepared = true
+	return nil
+}
+
+// prepare watch keys written by transaction, run its commit hooks and capture before-images,
+// the transaction is marked if mark is set
+func (p *participant) prepare(mark bool) error {
+	t := p.tx
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.expiredScope() != nil {
+		return ErrTransactionExpired
+	}
+	t.rollbackInner()
+	if t.err != nil {
+		return t.err
+	}
+	keys := []string{}
+	for _, cmd := range t.cmds {
+		if cmd.isWrite() {
+			keys = append(keys, cmd.keys()...)
+		}
+	}
+	if err := t.watch(keys...); err != nil {
+		return err
+	}
+	if t.session == nil && (t.changed() || t.readsChanged()) {
+		return ErrConflict
+	}
+	var err error
+	if p.images, err = t.prepare(true); err == nil && mark {
+		t.mark()
+	}
+	return err
+}
+
+// Commit every transaction, prepared first if needed. If a transaction fails the ones
+// already applied are restored from before-images and the others are rolled back.
+func (co *Coordinator) Commit() error {
+	if err := co.Prepare(); err != nil {
+		return err
+	}
+	o := &outcome{Time: time.Now(), Decision: decisionCommit}
+	for _, p := range co.participants {
+		o.Participants = append(o.Participants, &outcomeParticipant{
+			Name:     p.name,
+			ID:       p.tx.ID(),
+			Commands: p.tx.Pending(),
+			Images:   p.images,
+		})
+	}
+	id := newID()
+	if err := co.record(id, o); err != nil {
+		co.Rollback()
+		return &CoordinatorError{Err: err}
+	}
+	for i, p := range co.participants {
+		p.tx.mu.Lock()
+		err := p.tx.check() // expired since prepare
+		if err == nil {
+			p.applied, p.undone, err = p.tx.applyPrepared(p.images)
+		}
+		p.tx.mu.Unlock()
+		if err == nil {
+			o.Participants[i].Done = true
+			co.record(id, o)
+			continue
+		}
+		ce := &CoordinatorError{Participant: p.name, Err: err}
+		restored := i
+		if e, ok := err.(*CommitError); ok && !e.Compensated { // partially applied
+			o.Participants[i].Done = true
+			restored++
+		}
+		o.Decision = decisionAbort
+		co.record(id, o)
+		ce.CompensateErrors = co.abort(restored)
+		ce.Compensated = len(ce.CompensateErrors) == 0
+		if ce.Compensated {
+			co.remove(id)
+		}
+		return ce
+	}
+	co.remove(id)
+	for _, p := range co.participants {
+		p.tx.mu.Lock()
+		p.tx.finish(true, false)
+		p.tx.mu.Unlock()
+	}
+	co.participants = nil
+	co.prepared = false
+	return nil
+}
+
+// abort restore the first n transactions which were applied, then end the transactions
+// applied and roll back the others
+func (co *Coordinator) abort(n int) []error {
+	errs := []error{}
+	for i := n - 1; i >= 0; i-- {
+		p := co.participants[i]
+		if err := p.tx.c.recoverEntry(&journalEntry{Images: p.images}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", p.name, err))
+		}
+	}
+	for _, p := range co.participants {
+		p.tx.mu.Lock()
+		if p.applied {
+			p.tx.unmark()
+			p.tx.finish(false, true)
+		} else if p.tx.active {
+			p.tx.rollback()
+		}
+		p.tx.mu.Unlock()
+	}
+	co.participants = nil
+	co.prepared = false
+	return errs
+}
+
+// Rollback every transaction not committed
+func (co *Coordinator) Rollback() error {
+	var err error
+	for _, p := range co.participants {
+		if !p.tx.Active() {
+			continue
+		}
+		if e := p.tx.Rollback(); e != nil && err == nil {
+			err = &CoordinatorError{Participant: p.name, Err: e}
+		}
+	}
+	co.participants = nil
+	co.prepared = false
+	return err
+}
+
+// record write outcome to log
+func (co *Coordinator) record(id string, o *outcome) error {
+	if co.log == nil {
+		return nil
+	}
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return co.log.Write(id, data)
+}
+
+// remove drop outcome from log
+func (co *Coordinator) remove(id string) {
+	if co.log != nil {
+		co.log.Remove(id)
+	}
+}
+
+// Recover resolve commits left in log by interrupted coordinators, caches are the caches of
+// participants by name. Transactions of committed outcomes not yet applied are applied,
+// transactions of aborted outcomes already applied are restored from before-images.
+func (co *Coordinator) Recover(caches map[string]Cache) error {
+	entries, err := co.log.Entries()
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(entries))
+	outcomes := map[string]*outcome{}
+	errs := []string{}
+	for id, data := range entries {
+		o := &outcome{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(o); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", id, err))
+			continue
+		}
+		ids = append(ids, id)
+		outcomes[id] = o
+	}
+	sort.Slice(ids, func(a, b int) bool { return outcomes[ids[a]].Time.Before(outcomes[ids[b]].Time) })
+	for _, id := range ids {
+		if err := recoverOutcome(outcomes[id], caches); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", id, err))
+			continue
+		}
+		co.log.Remove(id)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cache: coordinator recovery failed (%s)", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// recoverOutcome apply or restore transactions of outcome, transactions whose marker was
+// written are not applied again
+func recoverOutcome(o *outcome, caches map[string]Cache) error {
+	for _, op := range o.Participants {
+		var e *journalEntry
+		switch {
+		case o.Decision == decisionCommit && !op.Done:
+			e = &journalEntry{ID: op.ID, Commands: op.Commands}
+		case o.Decision == decisionAbort && op.Done && len(op.Images) > 0:
+			e = &journalEntry{Images: op.Images}
+		default:
+			continue
+		}
+		c, ok := caches[op.Name].(*cacheImpl)
+		if !ok {
+			return fmt.Errorf("cache %s not found", op.Name)
+		}
+		if err := c.recoverEntry(e); err != nil {
+			return fmt.Errorf("%s: %s", op.Name, err)
+		}
+	}
+	return nil
+}
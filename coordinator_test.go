@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-lego/cache/driver"
+	dmock "github.com/go-lego/cache/driver/mock"
+	"github.com/golang/mock/gomock"
+)
+
+func TestCoordinatorCommit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d1 := dmock.NewMockDriver(ctrl)
+	d2 := dmock.NewMockDriver(ctrl)
+	c1 := newCacheImpl(Driver(d1))
+	c2 := newCacheImpl(Driver(d2))
+	log := &memJournal{entries: map[string][]byte{}}
+
+	co := NewCoordinator(log)
+	tx1 := c1.NewTransaction(WithID("t1"))
+	tx2 := c2.NewTransaction(WithID("t2"))
+	tx1.Set("session", "s1")
+	tx2.HSet("catalog", "item", 2)
+	if err := co.Enlist("session", tx1); err != nil {
+		t.Error("No error was expected to enlist, but: ", err)
+	}
+	co.Enlist("catalog", tx2)
+	c3 := newCacheImpl(Driver(d1))
+	c3.BeginTransaction()
+	if err := co.Enlist("nested", c3.BeginTransaction()); err != ErrNotEnlisted {
+		t.Error("ErrNotEnlisted was expected for savepoint, but: ", err)
+	}
+
+	gomock.InOrder(
+		d1.EXPECT().Get("session").Return("", driver.ErrValueNil),
+		d2.EXPECT().HGetAll("catalog").Return(map[string]string{}, nil),
+	)
+	if err := co.Prepare(); err != nil {
+		t.Error("No error was expected to prepare, but: ", err)
+	}
+	if err := co.Enlist("late", c1.NewTransaction()); err != ErrNotEnlisted {
+		t.Error("ErrNotEnlisted was expected after prepare, but: ", err)
+	}
+	applied := false
+	tx1.AfterCommit(func() {
+		if !applied {
+			t.Error("AfterCommit was expected to run once every transaction is applied")
+		}
+	})
+	gomock.InOrder(
+		d1.EXPECT().Set("session", "s1").Return(nil),
+		d1.EXPECT().Set("tx:t1", "1").Return(nil),
+		d1.EXPECT().PExpire("tx:t1", int64(86400000)).Return(nil),
+		d2.EXPECT().HSet("catalog", "item", 2).DoAndReturn(func(key string, hk string, value interface{}) error {
+			applied = true
+			return nil
+		}),
+		d2.EXPECT().Set("tx:t2", "1").Return(nil),
+		d2.EXPECT().PExpire("tx:t2", int64(86400000)).Return(nil),
+	)
+	if err := co.Commit(); err != nil {
+		t.Error("No error was expected to commit, but: ", err)
+	}
+	if log.writes != 3 || len(log.entries) != 0 {
+		t.Error("Outcome was expected to be recorded and removed, but: ", log.writes, log.entries)
+	}
+	if v, _ := c1.Get("session"); v != "s1" {
+		t.Error("Committed value was expected in memory, but: ", v)
+	}
+}
+
+func TestCoordinatorCommitFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d1 := dmock.NewMockDriver(ctrl)
+	d2 := dmock.NewMockDriver(ctrl)
+	c1 := newCacheImpl(Driver(d1))
+	c2 := newCacheImpl(Driver(d2))
+	log := &memJournal{entries: map[string][]byte{}}
+
+	co := NewCoordinator(log)
+	tx1 := c1.NewTransaction(WithID("t1"))
+	tx2 := c2.NewTransaction(WithID("t2"))
+	tx1.Set("session", "new")
+	tx2.Set("catalog", "new")
+	co.Enlist("session", tx1)
+	co.Enlist("catalog", tx2)
+	d1.EXPECT().Eval(lockScript, "lock:session", gomock.Any(), gomock.Any()).Return(int64(1), nil)
+	tx1.Lock("session")
+	committed, rolledBack := false, false
+	tx1.AfterCommit(func() { committed = true })
+	tx1.OnRollback(func() { rolledBack = true })
+
+	gomock.InOrder(
+		d1.EXPECT().Get("session").Return("old", nil),
+		d1.EXPECT().TTL("session").Return(time.Duration(-1), nil),
+		d2.EXPECT().Get("catalog").Return("", driver.ErrValueNil),
+		d1.EXPECT().Eval(renewScript, "lock:session", gomock.Any(), gomock.Any()).Return(int64(1), nil),
+		d1.EXPECT().Set("session", "new").Return(nil),
+		d1.EXPECT().Set("tx:t1", "1").Return(nil),
+		d1.EXPECT().PExpire("tx:t1", int64(86400000)).Return(nil),
+		d2.EXPECT().Set("catalog", "new").Return(errors.New("test")),
+		d2.EXPECT().Del("tx:t2").Return(nil),
+		// applied participant restored and unmarked
+		d1.EXPECT().Get("session").Return("new", nil),
+		d1.EXPECT().Del("session").Return(nil),
+		d1.EXPECT().Set("session", "old").Return(nil),
+		d1.EXPECT().Del("tx:t1").Return(nil),
+		// lock released once restored
+		d1.EXPECT().Eval(unlockScript, "lock:session", gomock.Any()).Return(int64(1), nil),
+	)
+	err := co.Commit()
+	ce, ok := err.(*CoordinatorError)
+	if !ok || ce.Participant != "catalog" || !ce.Compensated {
+		t.Fatal("Compensated CoordinatorError was expected to commit, but: ", err)
+	}
+	if committed || !rolledBack || tx1.Active() || tx2.Active() {
+		t.Error("Restored transaction was expected to run OnRollback callbacks only: ", committed, rolledBack)
+	}
+	if len(log.entries) != 0 {
+		t.Error("Compensated outcome was expected to be removed")
+	}
+	if _, ok := c1.shard("session").keys["session"]; ok {
+		t.Error("Restored key should be dropped from memory")
+	}
+}
+
+func TestCoordinatorPrepareConflict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d1 := dmock.NewMockDriver(ctrl)
+	d2 := dmock.NewMockDriver(ctrl)
+	c1 := newCacheImpl(Driver(d1))
+	c2 := newCacheImpl(Driver(d2))
+
+	co := NewCoordinator(nil)
+	tx1 := c1.NewTransaction()
+	tx2 := c2.NewTransaction()
+	tx1.Watch("session")
+	tx1.Set("session", "new")
+	tx2.Set("catalog", "new")
+	co.Enlist("catalog", tx2)
+	co.Enlist("session", tx1)
+
+	d1.EXPECT().Set("session", "other").Return(nil)
+	c1.Set("session", "other")
+
+	d2.EXPECT().Get("catalog").Return("", driver.ErrValueNil)
+	err := co.Commit()
+	if ce, ok := err.(*CoordinatorError); !ok || ce.Participant != "session" || ce.Err != ErrConflict {
+		t.Error("ErrConflict of session was expected to commit, but: ", err)
+	}
+	if tx1.(*transImpl).active || tx2.(*transImpl).active {
+		t.Error("Transactions were expected to be rolled back")
+	}
+}
+
+func TestCoordinatorRecover(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d1 := dmock.NewMockDriver(ctrl)
+	d2 := dmock.NewMockDriver(ctrl)
+	c1 := newCacheImpl(Driver(d1))
+	c2 := newCacheImpl(Driver(d2))
+	log := &memJournal{entries: map[string][]byte{
+		"id1": []byte(`{"time":"2020-01-01T00:00:00Z","decision":"commit","participants":[` +
+			`{"name":"session","commands":[{"op":"Set","key":"session","value":"s1"}],"done":true},` +
+			`{"name":"catalog","id":"t2","commands":[{"op":"HSet","key":"catalog","field":"item","value":2}],"done":false}]}`),
+		"id2": []byte(`{"time":"2020-01-01T00:00:01Z","decision":"abort","participants":[` +
+			`{"name":"session","commands":[{"op":"Set","key":"session","value":"s2"}],` +
+			`"images":[{"key":"session","exists":true,"value":"s1","ttl":-1}],"done":true},` +
+			`{"name":"catalog","commands":[{"op":"Del","key":"catalog"}],"done":false}]}`),
+		"id3": []byte(`{"time":"2020-01-01T00:00:02Z","decision":"commit","participants":[` +
+			`{"name":"catalog","id":"t3","commands":[{"op":"Del","key":"catalog"}],"done":false}]}`),
+	}}
+
+	gomock.InOrder(
+		d2.EXPECT().Get("tx:t2").Return("", driver.ErrValueNil),
+		d2.EXPECT().HSet("catalog", "item", int64(2)).Return(nil),
+		d1.EXPECT().Del("session").Return(nil),
+		d1.EXPECT().Set("session", "s1").Return(nil),
+		// marker written, not applied again
+		d2.EXPECT().Get("tx:t3").Return("1", nil),
+	)
+	if err := NewCoordinator(log).Recover(map[string]Cache{"session": c1, "catalog": c2}); err != nil {
+		t.Error("No error was expected to recover, but: ", err)
+	}
+	if len(log.entries) != 0 {
+		t.Error("Recovered outcomes were expected to be removed")
+	}
+}
@@ -1,6 +1,10 @@
 package driver
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 // Driver cache driver interface
 // Use mockgen to generate mocked struct:
@@ -32,9 +36,21 @@ type Driver interface {
 	// Check if the given key exists
 	Exists(key string) (bool, error)
 
-	// Expire set key expiration
+	// Expire set key expiration in seconds
 	Expire(key string, ex int64) error
 
+	// PExpire set key expiration in milliseconds
+	PExpire(key string, ms int64) error
+
+	// ExpireDuration set key expiration after duration d
+	ExpireDuration(key string, d time.Duration) error
+
+	// ExpireAt set key expiration at time tm
+	ExpireAt(key string, tm time.Time) error
+
+	// TTL get remaining time to live of key, -1 means no expiration, ErrValueNil if key not exist
+	TTL(key string) (time.Duration, error)
+
 	// Incr increment key
 	Incr(key string, delta interface{}) (string, error)
 
@@ -69,9 +85,107 @@ type Driver interface {
 
 	// HDecr decrement value of hash key
 	HDecr(key string, hk string, delta interface{}) (string, error)
+
+	// HExpire set expiration of hash keys in seconds
+	HExpire(key string, ex int64, hks ...string) error
+
+	// HPExpire set expiration of hash keys in milliseconds
+	HPExpire(key string, ms int64, hks ...string) error
+
+	// HTTL get remaining time to live of hash keys, -1 means no expiration, missing hash keys are omitted
+	HTTL(key string, hks ...string) (map[string]time.Duration, error)
+
+	// HPersist remove expiration of hash keys
+	HPersist(key string, hks ...string) error
+
+	// func for streams
+
+	// XAdd append message to stream, use "*" as id to let server generate it
+	XAdd(key string, id string, values map[string]interface{}) (string, error)
+
+	// XRange get messages with id between start and end, count <= 0 means no limit
+	XRange(key string, start string, end string, count int64) ([]StreamMessage, error)
+
+	// XRead read messages after the given ids (stream key => id), block > 0 waits up to block for new messages
+	XRead(streams map[string]string, count int64, block time.Duration) ([]Stream, error)
+
+	// XGroupCreate create consumer group starting at id start, mkstream creates the stream if not exist
+	XGroupCreate(key string, group string, start string, mkstream bool) error
+
+	// XReadGroup read messages as consumer of group, use ">" as id to get messages never delivered
+	XReadGroup(group string, consumer string, streams map[string]string, count int64, block time.Duration) ([]Stream, error)
+
+	// XAck acknowledge messages of group
+	XAck(key string, group string, ids ...string) (int64, error)
+
+	// XPending get pending messages of group, empty consumer means all consumers
+	XPending(key string, group string, start string, end string, count int64, consumer string) ([]PendingMessage, error)
+
+	// XClaim take ownership of pending messages idle for at least minIdle
+	XClaim(key string, group string, consumer string, minIdle time.Duration, ids ...string) ([]StreamMessage, error)
+
+	// func for pub/sub
+
+	// Publish post message to channel, returns the number of clients received it
+	Publish(channel string, msg interface{}) (int64, error)
+
+	// Subscribe subscribe channels
+	Subscribe(channels ...string) (Subscription, error)
+
+	// PSubscribe subscribe channels matching patterns
+	PSubscribe(patterns ...string) (Subscription, error)
+
+	// func for scripts
+
+	// Eval run script, the script is loaded once and executed by its hash afterwards
+	Eval(script *Script, keysAndArgs ...interface{}) (interface{}, error)
+
+	// EvalSha run script loaded before by its SHA1 hash
+	EvalSha(sha string, keyCount int, keysAndArgs ...interface{}) (interface{}, error)
+}
+
+// BatchSupport interface implemented by drivers able to apply multiple writes atomically
+type BatchSupport interface {
+	// Batch call fn with a driver queueing the writes instead of running them,
+	// the queued writes are applied atomically after fn returns without error.
+	// Calls on the queueing driver return ErrQueued, failures of applied writes are reported by BatchError.
+	Batch(fn func(d Driver) error) error
+}
+
+// WatchSupport interface implemented by drivers supporting optimistic locking
+type WatchSupport interface {
+	// Watch start session watching keys, writes applied by the session batch
+	// fail with ErrConflict if any watched key changed since it was watched
+	Watch(keys ...string) (WatchSession, error)
+}
+
+// WatchSession session holding watched keys
+type WatchSession interface {
+	BatchSupport
+
+	// Watch add keys to watch
+	Watch(keys ...string) error
+
+	// Close stop watching and release the session
+	Close() error
+}
+
+// BatchError error of batch, holds errors of failed commands indexed by the order they were queued
+type BatchError struct {
+	Errors map[int]error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("driver: %d batch command(s) failed", len(e.Errors))
 }
 
 var (
+	// ErrQueued error returned by the driver passed to Batch, indicates that the command is queued
+	ErrQueued = errors.New("driver: command queued")
+
+	// ErrConflict watched keys changed, batch not applied
+	ErrConflict = errors.New("driver: watched keys changed")
+
 	// ErrTypeNotSupported error indicates that the cache type is not supported yet
 	ErrTypeNotSupported = errors.New("driver: type not supported yet")
 
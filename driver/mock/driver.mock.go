@@ -8,6 +8,7 @@ import (
 	driver "github.com/go-lego/cache/driver"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
+	time "time"
 )
 
 // MockDriver is a mock of Driver interface
@@ -58,6 +59,42 @@ func (mr *MockDriverMockRecorder) Del(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Del", reflect.TypeOf((*MockDriver)(nil).Del), arg0)
 }
 
+// Eval mocks base method
+func (m *MockDriver) Eval(arg0 *driver.Script, arg1 ...interface{}) (interface{}, error) {
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Eval", varargs...)
+	ret0, _ := ret[0].(interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Eval indicates an expected call of Eval
+func (mr *MockDriverMockRecorder) Eval(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Eval", reflect.TypeOf((*MockDriver)(nil).Eval), varargs...)
+}
+
+// EvalSha mocks base method
+func (m *MockDriver) EvalSha(arg0 string, arg1 int, arg2 ...interface{}) (interface{}, error) {
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "EvalSha", varargs...)
+	ret0, _ := ret[0].(interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EvalSha indicates an expected call of EvalSha
+func (mr *MockDriverMockRecorder) EvalSha(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EvalSha", reflect.TypeOf((*MockDriver)(nil).EvalSha), varargs...)
+}
+
 // Exists mocks base method
 func (m *MockDriver) Exists(arg0 string) (bool, error) {
 	ret := m.ctrl.Call(m, "Exists", arg0)
@@ -83,6 +120,30 @@ func (mr *MockDriverMockRecorder) Expire(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Expire", reflect.TypeOf((*MockDriver)(nil).Expire), arg0, arg1)
 }
 
+// ExpireAt mocks base method
+func (m *MockDriver) ExpireAt(arg0 string, arg1 time.Time) error {
+	ret := m.ctrl.Call(m, "ExpireAt", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExpireAt indicates an expected call of ExpireAt
+func (mr *MockDriverMockRecorder) ExpireAt(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExpireAt", reflect.TypeOf((*MockDriver)(nil).ExpireAt), arg0, arg1)
+}
+
+// ExpireDuration mocks base method
+func (m *MockDriver) ExpireDuration(arg0 string, arg1 time.Duration) error {
+	ret := m.ctrl.Call(m, "ExpireDuration", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExpireDuration indicates an expected call of ExpireDuration
+func (mr *MockDriverMockRecorder) ExpireDuration(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExpireDuration", reflect.TypeOf((*MockDriver)(nil).ExpireDuration), arg0, arg1)
+}
+
 // Get mocks base method
 func (m *MockDriver) Get(arg0 string) (string, error) {
 	ret := m.ctrl.Call(m, "Get", arg0)
@@ -134,6 +195,23 @@ func (mr *MockDriverMockRecorder) HExists(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HExists", reflect.TypeOf((*MockDriver)(nil).HExists), arg0, arg1)
 }
 
+// HExpire mocks base method
+func (m *MockDriver) HExpire(arg0 string, arg1 int64, arg2 ...string) error {
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "HExpire", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// HExpire indicates an expected call of HExpire
+func (mr *MockDriverMockRecorder) HExpire(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HExpire", reflect.TypeOf((*MockDriver)(nil).HExpire), varargs...)
+}
+
 // HGet mocks base method
 func (m *MockDriver) HGet(arg0, arg1 string) (string, error) {
 	ret := m.ctrl.Call(m, "HGet", arg0, arg1)
@@ -198,6 +276,40 @@ func (mr *MockDriverMockRecorder) HMSet(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HMSet", reflect.TypeOf((*MockDriver)(nil).HMSet), arg0, arg1)
 }
 
+// HPExpire mocks base method
+func (m *MockDriver) HPExpire(arg0 string, arg1 int64, arg2 ...string) error {
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "HPExpire", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// HPExpire indicates an expected call of HPExpire
+func (mr *MockDriverMockRecorder) HPExpire(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HPExpire", reflect.TypeOf((*MockDriver)(nil).HPExpire), varargs...)
+}
+
+// HPersist mocks base method
+func (m *MockDriver) HPersist(arg0 string, arg1 ...string) error {
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "HPersist", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// HPersist indicates an expected call of HPersist
+func (mr *MockDriverMockRecorder) HPersist(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HPersist", reflect.TypeOf((*MockDriver)(nil).HPersist), varargs...)
+}
+
 // HSet mocks base method
 func (m *MockDriver) HSet(arg0, arg1 string, arg2 interface{}) error {
 	ret := m.ctrl.Call(m, "HSet", arg0, arg1, arg2)
@@ -210,6 +322,24 @@ func (mr *MockDriverMockRecorder) HSet(arg0, arg1, arg2 interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HSet", reflect.TypeOf((*MockDriver)(nil).HSet), arg0, arg1, arg2)
 }
 
+// HTTL mocks base method
+func (m *MockDriver) HTTL(arg0 string, arg1 ...string) (map[string]time.Duration, error) {
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "HTTL", varargs...)
+	ret0, _ := ret[0].(map[string]time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HTTL indicates an expected call of HTTL
+func (mr *MockDriverMockRecorder) HTTL(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HTTL", reflect.TypeOf((*MockDriver)(nil).HTTL), varargs...)
+}
+
 // Incr mocks base method
 func (m *MockDriver) Incr(arg0 string, arg1 interface{}) (string, error) {
 	ret := m.ctrl.Call(m, "Incr", arg0, arg1)
@@ -272,6 +402,49 @@ func (mr *MockDriverMockRecorder) Options() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Options", reflect.TypeOf((*MockDriver)(nil).Options))
 }
 
+// PExpire mocks base method
+func (m *MockDriver) PExpire(arg0 string, arg1 int64) error {
+	ret := m.ctrl.Call(m, "PExpire", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PExpire indicates an expected call of PExpire
+func (mr *MockDriverMockRecorder) PExpire(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PExpire", reflect.TypeOf((*MockDriver)(nil).PExpire), arg0, arg1)
+}
+
+// PSubscribe mocks base method
+func (m *MockDriver) PSubscribe(arg0 ...string) (driver.Subscription, error) {
+	varargs := []interface{}{}
+	for _, a := range arg0 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "PSubscribe", varargs...)
+	ret0, _ := ret[0].(driver.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PSubscribe indicates an expected call of PSubscribe
+func (mr *MockDriverMockRecorder) PSubscribe(arg0 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{}, arg0...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PSubscribe", reflect.TypeOf((*MockDriver)(nil).PSubscribe), varargs...)
+}
+
+// Publish mocks base method
+func (m *MockDriver) Publish(arg0 string, arg1 interface{}) (int64, error) {
+	ret := m.ctrl.Call(m, "Publish", arg0, arg1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Publish indicates an expected call of Publish
+func (mr *MockDriverMockRecorder) Publish(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Publish", reflect.TypeOf((*MockDriver)(nil).Publish), arg0, arg1)
+}
+
 // Set mocks base method
 func (m *MockDriver) Set(arg0 string, arg1 interface{}) error {
 	ret := m.ctrl.Call(m, "Set", arg0, arg1)
@@ -283,3 +456,147 @@ func (m *MockDriver) Set(arg0 string, arg1 interface{}) error {
 func (mr *MockDriverMockRecorder) Set(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockDriver)(nil).Set), arg0, arg1)
 }
+
+// Subscribe mocks base method
+func (m *MockDriver) Subscribe(arg0 ...string) (driver.Subscription, error) {
+	varargs := []interface{}{}
+	for _, a := range arg0 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Subscribe", varargs...)
+	ret0, _ := ret[0].(driver.Subscription)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Subscribe indicates an expected call of Subscribe
+func (mr *MockDriverMockRecorder) Subscribe(arg0 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{}, arg0...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribe", reflect.TypeOf((*MockDriver)(nil).Subscribe), varargs...)
+}
+
+// TTL mocks base method
+func (m *MockDriver) TTL(arg0 string) (time.Duration, error) {
+	ret := m.ctrl.Call(m, "TTL", arg0)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TTL indicates an expected call of TTL
+func (mr *MockDriverMockRecorder) TTL(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TTL", reflect.TypeOf((*MockDriver)(nil).TTL), arg0)
+}
+
+// XAck mocks base method
+func (m *MockDriver) XAck(arg0, arg1 string, arg2 ...string) (int64, error) {
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "XAck", varargs...)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// XAck indicates an expected call of XAck
+func (mr *MockDriverMockRecorder) XAck(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "XAck", reflect.TypeOf((*MockDriver)(nil).XAck), varargs...)
+}
+
+// XAdd mocks base method
+func (m *MockDriver) XAdd(arg0, arg1 string, arg2 map[string]interface{}) (string, error) {
+	ret := m.ctrl.Call(m, "XAdd", arg0, arg1, arg2)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// XAdd indicates an expected call of XAdd
+func (mr *MockDriverMockRecorder) XAdd(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "XAdd", reflect.TypeOf((*MockDriver)(nil).XAdd), arg0, arg1, arg2)
+}
+
+// XClaim mocks base method
+func (m *MockDriver) XClaim(arg0, arg1, arg2 string, arg3 time.Duration, arg4 ...string) ([]driver.StreamMessage, error) {
+	varargs := []interface{}{arg0, arg1, arg2, arg3}
+	for _, a := range arg4 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "XClaim", varargs...)
+	ret0, _ := ret[0].([]driver.StreamMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// XClaim indicates an expected call of XClaim
+func (mr *MockDriverMockRecorder) XClaim(arg0, arg1, arg2, arg3 interface{}, arg4 ...interface{}) *gomock.Call {
+	varargs := append([]interface{}{arg0, arg1, arg2, arg3}, arg4...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "XClaim", reflect.TypeOf((*MockDriver)(nil).XClaim), varargs...)
+}
+
+// XGroupCreate mocks base method
+func (m *MockDriver) XGroupCreate(arg0, arg1, arg2 string, arg3 bool) error {
+	ret := m.ctrl.Call(m, "XGroupCreate", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// XGroupCreate indicates an expected call of XGroupCreate
+func (mr *MockDriverMockRecorder) XGroupCreate(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "XGroupCreate", reflect.TypeOf((*MockDriver)(nil).XGroupCreate), arg0, arg1, arg2, arg3)
+}
+
+// XPending mocks base method
+func (m *MockDriver) XPending(arg0, arg1, arg2, arg3 string, arg4 int64, arg5 string) ([]driver.PendingMessage, error) {
+	ret := m.ctrl.Call(m, "XPending", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].([]driver.PendingMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// XPending indicates an expected call of XPending
+func (mr *MockDriverMockRecorder) XPending(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "XPending", reflect.TypeOf((*MockDriver)(nil).XPending), arg0, arg1, arg2, arg3, arg4, arg5)
+}
+
+// XRange mocks base method
+func (m *MockDriver) XRange(arg0, arg1, arg2 string, arg3 int64) ([]driver.StreamMessage, error) {
+	ret := m.ctrl.Call(m, "XRange", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]driver.StreamMessage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// XRange indicates an expected call of XRange
+func (mr *MockDriverMockRecorder) XRange(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "XRange", reflect.TypeOf((*MockDriver)(nil).XRange), arg0, arg1, arg2, arg3)
+}
+
+// XRead mocks base method
+func (m *MockDriver) XRead(arg0 map[string]string, arg1 int64, arg2 time.Duration) ([]driver.Stream, error) {
+	ret := m.ctrl.Call(m, "XRead", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]driver.Stream)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// XRead indicates an expected call of XRead
+func (mr *MockDriverMockRecorder) XRead(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "XRead", reflect.TypeOf((*MockDriver)(nil).XRead), arg0, arg1, arg2)
+}
+
+// XReadGroup mocks base method
+func (m *MockDriver) XReadGroup(arg0, arg1 string, arg2 map[string]string, arg3 int64, arg4 time.Duration) ([]driver.Stream, error) {
+	ret := m.ctrl.Call(m, "XReadGroup", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].([]driver.Stream)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// XReadGroup indicates an expected call of XReadGroup
+func (mr *MockDriverMockRecorder) XReadGroup(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "XReadGroup", reflect.TypeOf((*MockDriver)(nil).XReadGroup), arg0, arg1, arg2, arg3, arg4)
+}
@@ -0,0 +1,17 @@
+package driver
+
+// Message message received from a subscription
+type Message struct {
+	Channel string // channel the message was published to
+	Pattern string // pattern matched by the channel, empty for plain subscription
+	Data    []byte // message payload
+}
+
+// Subscription subscription of channels or patterns
+type Subscription interface {
+	// Messages get received messages, the channel is closed after Close
+	Messages() <-chan Message
+
+	// Close unsubscribe and release the connection
+	Close() error
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -17,7 +19,11 @@ type redisPool interface {
 type redisDriver struct {
 	options Options
 	pool    redisPool
-	test    bool // test mode is used for fixing the issue caused by map iterating
+	dial    func() (redis.Conn, error) // dial new connection to server
+	test    bool                       // test mode is used for fixing the issue caused by map iterating
+
+	mu      sync.Mutex
+	scripts map[string]bool // hashes of scripts loaded on server
 }
 
 // newredisDriver create new redis cache
@@ -25,6 +31,9 @@ func newRedisDriver(opts Options) Driver {
 	c := &redisDriver{
 		options: opts,
 	}
+	c.dial = func() (redis.Conn, error) {
+		return redis.Dial("tcp", fmt.Sprintf("%s:%d", opts.Host, opts.Port), redis.DialPassword(opts.Password))
+	}
 	return c
 }
 
@@ -35,14 +44,11 @@ func (r *redisDriver) Options() Options {
 
 // Init initialize redis connection
 func (r *redisDriver) Init() error {
-	opts := r.options
 	p := &redis.Pool{
 		MaxIdle:     5,
 		MaxActive:   0,
 		IdleTimeout: 2 * time.Minute,
-		Dial: func() (redis.Conn, error) {
-			return redis.Dial("tcp", fmt.Sprintf("%s:%d", opts.Host, opts.Port), redis.DialPassword(opts.Password))
-		},
+		Dial:        r.dial,
 	}
 	t := p.Get()
 	defer t.Close()
@@ -53,6 +59,34 @@ func (r *redisDriver) Init() error {
 	return nil
 }
 
+// appendPairs append key-value pairs of kvs to args
+func (r *redisDriver) appendPairs(args []interface{}, kvs map[string]interface{}) []interface{} {
+	if r.test { // sort keys in test mode
+		sks := []string{}
+		for k := range kvs {
+			sks = append(sks, k)
+		}
+		sort.Strings(sks)
+		for _, k := range sks {
+			args = append(args, k, kvs[k])
+		}
+		return args
+	}
+	for k, v := range kvs {
+		args = append(args, k, v)
+	}
+	return args
+}
+
+// millis convert duration to milliseconds, positive duration is at least 1ms
+func millis(d time.Duration) int64 {
+	ms := int64(d / time.Millisecond)
+	if ms == 0 && d > 0 {
+		ms = 1
+	}
+	return ms
+}
+
 // func for keys
 
 // Get value by key
@@ -99,26 +133,7 @@ func (r *redisDriver) MGet(keys []string) (map[string]string, error) {
 func (r *redisDriver) MSet(kvs map[string]interface{}) error {
 	c := r.pool.Get()
 	defer c.Close()
-	tmp := make([]interface{}, len(kvs)*2)
-	i := 0
-	if r.test { // sort keys in test mode
-		sks := []string{}
-		for k := range kvs {
-			sks = append(sks, k)
-		}
-		sort.Slice(sks, func(i, j int) bool { return sks[i] < sks[j] })
-		for _, k := range sks {
-			tmp[i] = k
-			tmp[i+1] = kvs[k]
-			i += 2
-		}
-	} else {
-		for k, v := range kvs {
-			tmp[i] = k
-			tmp[i+1] = v
-			i += 2
-		}
-	}
+	tmp := r.appendPairs(make([]interface{}, 0, len(kvs)*2), kvs)
 	_, err := redis.String(c.Do("MSET", tmp...))
 	if err != nil {
 		return err
@@ -141,7 +156,7 @@ func (r *redisDriver) Exists(key string) (bool, error) {
 	return redis.Bool(c.Do("EXISTS", key))
 }
 
-// Expire set key expiration
+// Expire set key expiration in seconds
 func (r *redisDriver) Expire(key string, ex int64) error {
 	c := r.pool.Get()
 	defer c.Close()
@@ -149,6 +164,44 @@ func (r *redisDriver) Expire(key string, ex int64) error {
 	return err
 }
 
+// PExpire set key expiration in milliseconds
+func (r *redisDriver) PExpire(key string, ms int64) error {
+	c := r.pool.Get()
+	defer c.Close()
+	_, err := c.Do("PEXPIRE", key, ms)
+	return err
+}
+
+// ExpireDuration set key expiration after duration d
+func (r *redisDriver) ExpireDuration(key string, d time.Duration) error {
+	return r.PExpire(key, millis(d))
+}
+
+// ExpireAt set key expiration at time tm
+func (r *redisDriver) ExpireAt(key string, tm time.Time) error {
+	c := r.pool.Get()
+	defer c.Close()
+	_, err := c.Do("PEXPIREAT", key, tm.UnixNano()/int64(time.Millisecond))
+	return err
+}
+
+// TTL get remaining time to live of key, -1 means no expiration, ErrValueNil if key not exist
+func (r *redisDriver) TTL(key string) (time.Duration, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	ms, err := redis.Int64(c.Do("PTTL", key))
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case ms == -2:
+		return 0, ErrValueNil
+	case ms < 0:
+		return -1, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
 // Incr increment key
 func (r *redisDriver) Incr(key string, delta interface{}) (string, error) {
 	c := r.pool.Get()
@@ -226,27 +279,7 @@ func (r *redisDriver) HMGet(key string, hks []string) (map[string]string, error)
 func (r *redisDriver) HMSet(key string, kvs map[string]interface{}) error {
 	c := r.pool.Get()
 	defer c.Close()
-	tmp := make([]interface{}, len(kvs)*2+1)
-	tmp[0] = key
-	i := 1
-	if r.test { // sort keys in test mode
-		sks := []string{}
-		for k := range kvs {
-			sks = append(sks, k)
-		}
-		sort.Slice(sks, func(i, j int) bool { return sks[i] < sks[j] })
-		for _, k := range sks {
-			tmp[i] = k
-			tmp[i+1] = kvs[k]
-			i += 2
-		}
-	} else {
-		for k, v := range kvs {
-			tmp[i] = k
-			tmp[i+1] = v
-			i += 2
-		}
-	}
+	tmp := r.appendPairs(append(make([]interface{}, 0, len(kvs)*2+1), key), kvs)
 	_, err := c.Do("HMSET", tmp...)
 	return err
 }
@@ -321,6 +354,432 @@ func (r *redisDriver) HDecr(key string, hk string, delta interface{}) (string, e
 	return "", errors.New("driver redis: invalid delta value")
 }
 
+// appendFields append "FIELDS numfields field..." to args
+func appendFields(args []interface{}, hks []string) []interface{} {
+	args = append(args, "FIELDS", len(hks))
+	for _, hk := range hks {
+		args = append(args, hk)
+	}
+	return args
+}
+
+// HExpire set expiration of hash keys in seconds
+func (r *redisDriver) HExpire(key string, ex int64, hks ...string) error {
+	c := r.pool.Get()
+	defer c.Close()
+	_, err := c.Do("HEXPIRE", appendFields([]interface{}{key, ex}, hks)...)
+	return err
+}
+
+// HPExpire set expiration of hash keys in milliseconds
+func (r *redisDriver) HPExpire(key string, ms int64, hks ...string) error {
+	c := r.pool.Get()
+	defer c.Close()
+	_, err := c.Do("HPEXPIRE", appendFields([]interface{}{key, ms}, hks)...)
+	return err
+}
+
+// HTTL get remaining time to live of hash keys, -1 means no expiration, missing hash keys are omitted
+func (r *redisDriver) HTTL(key string, hks ...string) (map[string]time.Duration, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	ttls, err := redis.Int64s(c.Do("HPTTL", appendFields([]interface{}{key}, hks)...))
+	if err == redis.ErrNil { // key not exist
+		return map[string]time.Duration{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]time.Duration, len(hks))
+	for i, ttl := range ttls {
+		switch {
+		case ttl == -2: // hash key not exist
+		case ttl < 0:
+			ret[hks[i]] = -1
+		default:
+			ret[hks[i]] = time.Duration(ttl) * time.Millisecond
+		}
+	}
+	return ret, nil
+}
+
+// HPersist remove expiration of hash keys
+func (r *redisDriver) HPersist(key string, hks ...string) error {
+	c := r.pool.Get()
+	defer c.Close()
+	_, err := c.Do("HPERSIST", appendFields([]interface{}{key}, hks)...)
+	return err
+}
+
+// func for streams
+
+var errInvalidStreamReply = errors.New("driver redis: invalid stream reply")
+
+// appendStreams append "STREAMS key... id..." to args, keys are sorted to keep arguments stable
+func appendStreams(args []interface{}, streams map[string]string) []interface{} {
+	keys := make([]string, 0, len(streams))
+	for k := range streams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args = append(args, "STREAMS")
+	for _, k := range keys {
+		args = append(args, k)
+	}
+	for _, k := range keys {
+		args = append(args, streams[k])
+	}
+	return args
+}
+
+// parseStreamMessages parse reply of [[id, [field, value...]]...]
+func parseStreamMessages(reply interface{}, err error) ([]StreamMessage, error) {
+	vals, err := redis.Values(reply, err)
+	if err == redis.ErrNil {
+		return []StreamMessage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]StreamMessage, 0, len(vals))
+	for _, v := range vals {
+		if v == nil { // message deleted
+			continue
+		}
+		entry, err := redis.Values(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(entry) != 2 {
+			return nil, errInvalidStreamReply
+		}
+		id, err := redis.String(entry[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		values := map[string]string{}
+		if entry[1] != nil {
+			if values, err = redis.StringMap(entry[1], nil); err != nil {
+				return nil, err
+			}
+		}
+		msgs = append(msgs, StreamMessage{ID: id, Values: values})
+	}
+	return msgs, nil
+}
+
+// parseStreams parse reply of [[key, messages]...], nil reply means timeout
+func parseStreams(reply interface{}, err error) ([]Stream, error) {
+	vals, err := redis.Values(reply, err)
+	if err == redis.ErrNil {
+		return []Stream{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]Stream, 0, len(vals))
+	for _, v := range vals {
+		entry, err := redis.Values(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(entry) != 2 {
+			return nil, errInvalidStreamReply
+		}
+		key, err := redis.String(entry[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := parseStreamMessages(entry[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, Stream{Key: key, Messages: msgs})
+	}
+	return ret, nil
+}
+
+// XAdd append message to stream, use "*" as id to let server generate it
+func (r *redisDriver) XAdd(key string, id string, values map[string]interface{}) (string, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	tmp := r.appendPairs(append(make([]interface{}, 0, len(values)*2+2), key, id), values)
+	return redis.String(c.Do("XADD", tmp...))
+}
+
+// XRange get messages with id between start and end, count <= 0 means no limit
+func (r *redisDriver) XRange(key string, start string, end string, count int64) ([]StreamMessage, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	tmp := []interface{}{key, start, end}
+	if count > 0 {
+		tmp = append(tmp, "COUNT", count)
+	}
+	return parseStreamMessages(c.Do("XRANGE", tmp...))
+}
+
+// XRead read messages after the given ids (stream key => id), block > 0 waits up to block for new messages
+func (r *redisDriver) XRead(streams map[string]string, count int64, block time.Duration) ([]Stream, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	tmp := []interface{}{}
+	if count > 0 {
+		tmp = append(tmp, "COUNT", count)
+	}
+	if block > 0 {
+		tmp = append(tmp, "BLOCK", millis(block))
+	}
+	return parseStreams(c.Do("XREAD", appendStreams(tmp, streams)...))
+}
+
+// XGroupCreate create consumer group starting at id start, mkstream creates the stream if not exist
+func (r *redisDriver) XGroupCreate(key string, group string, start string, mkstream bool) error {
+	c := r.pool.Get()
+	defer c.Close()
+	tmp := []interface{}{"CREATE", key, group, start}
+	if mkstream {
+		tmp = append(tmp, "MKSTREAM")
+	}
+	_, err := c.Do("XGROUP", tmp...)
+	return err
+}
+
+// XReadGroup read messages as consumer of group, use ">" as id to get messages never delivered
+func (r *redisDriver) XReadGroup(group string, consumer string, streams map[string]string, count int64, block time.Duration) ([]Stream, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	tmp := []interface{}{"GROUP", group, consumer}
+	if count > 0 {
+		tmp = append(tmp, "COUNT", count)
+	}
+	if block > 0 {
+		tmp = append(tmp, "BLOCK", millis(block))
+	}
+	return parseStreams(c.Do("XREADGROUP", appendStreams(tmp, streams)...))
+}
+
+// XAck acknowledge messages of group
+func (r *redisDriver) XAck(key string, group string, ids ...string) (int64, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	tmp := []interface{}{key, group}
+	for _, id := range ids {
+		tmp = append(tmp, id)
+	}
+	return redis.Int64(c.Do("XACK", tmp...))
+}
+
+// XPending get pending messages of group, empty consumer means all consumers
+func (r *redisDriver) XPending(key string, group string, start string, end string, count int64, consumer string) ([]PendingMessage, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	tmp := []interface{}{key, group, start, end, count}
+	if consumer != "" {
+		tmp = append(tmp, consumer)
+	}
+	vals, err := redis.Values(c.Do("XPENDING", tmp...))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]PendingMessage, 0, len(vals))
+	for _, v := range vals {
+		entry, err := redis.Values(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(entry) != 4 {
+			return nil, errInvalidStreamReply
+		}
+		var pm PendingMessage
+		var idle int64
+		if _, err := redis.Scan(entry, &pm.ID, &pm.Consumer, &idle, &pm.Deliveries); err != nil {
+			return nil, err
+		}
+		pm.Idle = time.Duration(idle) * time.Millisecond
+		ret = append(ret, pm)
+	}
+	return ret, nil
+}
+
+// XClaim take ownership of pending messages idle for at least minIdle
+func (r *redisDriver) XClaim(key string, group string, consumer string, minIdle time.Duration, ids ...string) ([]StreamMessage, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	tmp := []interface{}{key, group, consumer, millis(minIdle)}
+	for _, id := range ids {
+		tmp = append(tmp, id)
+	}
+	return parseStreamMessages(c.Do("XCLAIM", tmp...))
+}
+
+// func for scripts
+
+// scriptLoaded check if script was loaded on server
+func (r *redisDriver) scriptLoaded(hash string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.scripts[hash]
+}
+
+// setScriptLoaded mark script loaded or not
+func (r *redisDriver) setScriptLoaded(hash string, loaded bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.scripts == nil {
+		r.scripts = map[string]bool{}
+	}
+	if loaded {
+		r.scripts[hash] = true
+	} else {
+		delete(r.scripts, hash)
+	}
+}
+
+// Eval run script, the script is loaded once and executed by its hash afterwards
+func (r *redisDriver) Eval(script *Script, keysAndArgs ...interface{}) (interface{}, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	hash := script.Hash()
+	if !r.scriptLoaded(hash) {
+		if _, err := c.Do("SCRIPT", "LOAD", script.Source()); err != nil {
+			return nil, err
+		}
+		r.setScriptLoaded(hash, true)
+	}
+	tmp := append([]interface{}{hash, script.KeyCount()}, keysAndArgs...)
+	v, err := c.Do("EVALSHA", tmp...)
+	if e, ok := err.(redis.Error); ok && strings.HasPrefix(string(e), "NOSCRIPT ") {
+		// script cache flushed on server, EVAL loads it again
+		r.setScriptLoaded(hash, false)
+		tmp[0] = script.Source()
+		v, err = c.Do("EVAL", tmp...)
+		if err == nil {
+			r.setScriptLoaded(hash, true)
+		}
+	}
+	return v, err
+}
+
+// EvalSha run script loaded before by its SHA1 hash
+func (r *redisDriver) EvalSha(sha string, keyCount int, keysAndArgs ...interface{}) (interface{}, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	tmp := append([]interface{}{sha, keyCount}, keysAndArgs...)
+	return c.Do("EVALSHA", tmp...)
+}
+
+// func for batch
+
+// queuedConn connection sending commands without waiting for replies
+type queuedConn struct {
+	redis.Conn
+}
+
+// Do send command, the reply is read on EXEC
+func (c *queuedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if err := c.Conn.Send(cmd, args...); err != nil {
+		return nil, err
+	}
+	return nil, ErrQueued
+}
+
+// Close leave the connection to the batch owning it
+func (c *queuedConn) Close() error {
+	return nil
+}
+
+// queuedPool pool always returning the same queued connection
+type queuedPool struct {
+	conn *queuedConn
+}
+
+func (p *queuedPool) Get() redis.Conn {
+	return p.conn
+}
+
+// Batch call fn with a driver queueing the writes inside MULTI, then apply them with EXEC
+func (r *redisDriver) Batch(fn func(d Driver) error) error {
+	c := r.pool.Get()
+	defer c.Close()
+	return r.batch(c, fn)
+}
+
+// batch run MULTI/EXEC on connection c
+func (r *redisDriver) batch(c redis.Conn, fn func(d Driver) error) error {
+	if err := c.Send("MULTI"); err != nil {
+		return err
+	}
+	b := &redisDriver{
+		options: r.options,
+		pool:    &queuedPool{conn: &queuedConn{Conn: c}},
+		dial:    r.dial,
+		test:    r.test,
+	}
+	if err := fn(b); err != nil {
+		c.Do("DISCARD")
+		return err
+	}
+	replies, err := redis.Values(c.Do("EXEC"))
+	if err == redis.ErrNil { // aborted by WATCH
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	be := &BatchError{Errors: map[int]error{}}
+	for i, reply := range replies {
+		if e, ok := reply.(redis.Error); ok {
+			be.Errors[i] = e
+		}
+	}
+	if len(be.Errors) > 0 {
+		return be
+	}
+	return nil
+}
+
+// redisWatchSession session holding a connection with watched keys
+type redisWatchSession struct {
+	r    *redisDriver
+	conn redis.Conn
+}
+
+// Watch start session watching keys
+func (r *redisDriver) Watch(keys ...string) (WatchSession, error) {
+	s := &redisWatchSession{
+		r:    r,
+		conn: r.pool.Get(),
+	}
+	if err := s.Watch(keys...); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Watch add keys to watch
+func (s *redisWatchSession) Watch(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	tmp := make([]interface{}, len(keys))
+	for i, k := range keys {
+		tmp[i] = k
+	}
+	_, err := s.conn.Do("WATCH", tmp...)
+	return err
+}
+
+// Batch apply writes with MULTI/EXEC, fails with ErrConflict if any watched key changed
+func (s *redisWatchSession) Batch(fn func(d Driver) error) error {
+	return s.r.batch(s.conn, fn)
+}
+
+// Close stop watching and release the connection
+func (s *redisWatchSession) Close() error {
+	return s.conn.Close()
+}
+
 // BeforeCreate called before transaction creation
 func (r *redisDriver) BeforeCreate() error {
 	return nil
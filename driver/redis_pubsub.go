@@ -0,0 +1,146 @@
+package driver
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// resubscribeInterval interval between reconnect attempts of a broken subscription
+var resubscribeInterval = time.Second
+
+// Publish post message to channel, returns the number of clients received it
+func (r *redisDriver) Publish(channel string, msg interface{}) (int64, error) {
+	c := r.pool.Get()
+	defer c.Close()
+	return redis.Int64(c.Do("PUBLISH", channel, msg))
+}
+
+// Subscribe subscribe channels
+func (r *redisDriver) Subscribe(channels ...string) (Subscription, error) {
+	return newRedisSubscription(r, false, channels)
+}
+
+// PSubscribe subscribe channels matching patterns
+func (r *redisDriver) PSubscribe(patterns ...string) (Subscription, error) {
+	return newRedisSubscription(r, true, patterns)
+}
+
+// redisSubscription subscription running on a dedicated connection,
+// channels are subscribed again after the connection is re-established
+type redisSubscription struct {
+	r        *redisDriver
+	pattern  bool
+	channels []interface{}
+	msgs     chan Message
+	done     chan struct{}
+
+	mu     sync.Mutex
+	conn   redis.PubSubConn
+	closed bool
+}
+
+// newRedisSubscription subscribe channels (or patterns) and start receiving messages
+func newRedisSubscription(r *redisDriver, pattern bool, channels []string) (*redisSubscription, error) {
+	s := &redisSubscription{
+		r:        r,
+		pattern:  pattern,
+		channels: make([]interface{}, len(channels)),
+		msgs:     make(chan Message, 64),
+		done:     make(chan struct{}),
+	}
+	for i, ch := range channels {
+		s.channels[i] = ch
+	}
+	if err := s.subscribe(); err != nil {
+		return nil, err
+	}
+	go s.receive()
+	return s, nil
+}
+
+// subscribe dial new connection and subscribe channels on it
+func (s *redisSubscription) subscribe() error {
+	c, err := s.r.dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: c}
+	if s.pattern {
+		err = psc.PSubscribe(s.channels...)
+	} else {
+		err = psc.Subscribe(s.channels...)
+	}
+	if err != nil {
+		c.Close()
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		c.Close()
+		return nil
+	}
+	s.conn = psc
+	return nil
+}
+
+// receive deliver messages until closed, reconnecting on connection errors
+func (s *redisSubscription) receive() {
+	defer close(s.msgs)
+	for {
+		s.mu.Lock()
+		psc := s.conn
+		s.mu.Unlock()
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			select {
+			case s.msgs <- Message{Channel: v.Channel, Pattern: v.Pattern, Data: v.Data}:
+			case <-s.done:
+				return
+			}
+		case error:
+			psc.Close()
+			if !s.reconnect() {
+				return
+			}
+		}
+	}
+}
+
+// reconnect retry subscribing until success, returns false if closed meanwhile
+func (s *redisSubscription) reconnect() bool {
+	for {
+		select {
+		case <-s.done:
+			return false
+		case <-time.After(resubscribeInterval):
+		}
+		if err := s.subscribe(); err == nil {
+			select {
+			case <-s.done:
+				return false
+			default:
+				return true
+			}
+		}
+	}
+}
+
+// Messages get received messages, the channel is closed after Close
+func (s *redisSubscription) Messages() <-chan Message {
+	return s.msgs
+}
+
+// Close unsubscribe and release the connection
+func (s *redisSubscription) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	return s.conn.Close()
+}
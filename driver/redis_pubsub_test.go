@@ -0,0 +1,120 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/redigomock"
+)
+
+// testDialer dial the given connections in order, then fails
+func testDialer(conns ...redis.Conn) func() (redis.Conn, error) {
+	return func() (redis.Conn, error) {
+		if len(conns) == 0 {
+			return nil, errors.New("test: dial failed")
+		}
+		c := conns[0]
+		conns = conns[1:]
+		return c, nil
+	}
+}
+
+func receiveMessage(t *testing.T, sub Subscription) Message {
+	select {
+	case m := <-sub.Messages():
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("Message was expected, but timeout")
+	}
+	return Message{}
+}
+
+func TestRedisPublish(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("PUBLISH", "news", "hello").Expect(int64(2))
+
+	n, err := r.Publish("news", "hello")
+	if err != nil {
+		t.Error("No error was expected to publish, but: ", err)
+	}
+	if n != 2 {
+		t.Error("Publish receivers was expected to 2, but: ", n)
+	}
+}
+
+func TestRedisSubscribe(t *testing.T) {
+	resubscribeInterval = time.Millisecond
+	defer func() { resubscribeInterval = time.Second }()
+
+	c1 := redigomock.NewConn()
+	c1.Command("SUBSCRIBE", "news").Expect([]interface{}{[]byte("subscribe"), []byte("news"), int64(1)})
+	c1.AddSubscriptionMessage([]interface{}{[]byte("message"), []byte("news"), []byte("hello")})
+	c2 := redigomock.NewConn()
+	c2.Command("SUBSCRIBE", "news").Expect([]interface{}{[]byte("subscribe"), []byte("news"), int64(1)})
+	c2.AddSubscriptionMessage([]interface{}{[]byte("message"), []byte("news"), []byte("world")})
+	r := &redisDriver{
+		dial: testDialer(c1, c2),
+	}
+
+	sub, err := r.Subscribe("news")
+	if err != nil {
+		t.Fatal("No error was expected to subscribe, but: ", err)
+	}
+	m := receiveMessage(t, sub)
+	if m.Channel != "news" || string(m.Data) != "hello" {
+		t.Error("Message was incorrect: ", m)
+	}
+	// received after the broken connection was re-established
+	m = receiveMessage(t, sub)
+	if m.Channel != "news" || string(m.Data) != "world" {
+		t.Error("Message after resubscribe was incorrect: ", m)
+	}
+
+	if err := sub.Close(); err != nil {
+		t.Error("No error was expected to close, but: ", err)
+	}
+	select {
+	case _, ok := <-sub.Messages():
+		if ok {
+			t.Error("Messages channel should be closed after close")
+		}
+	case <-time.After(time.Second):
+		t.Error("Messages channel was not closed after close")
+	}
+}
+
+func TestRedisSubscribeDialError(t *testing.T) {
+	r := &redisDriver{
+		dial: testDialer(),
+	}
+
+	_, err := r.Subscribe("news")
+	if err == nil {
+		t.Error("Dial error was expected to subscribe")
+	}
+}
+
+func TestRedisPSubscribe(t *testing.T) {
+	c := redigomock.NewConn()
+	c.Command("PSUBSCRIBE", "news.*").Expect([]interface{}{[]byte("psubscribe"), []byte("news.*"), int64(1)})
+	c.AddSubscriptionMessage([]interface{}{[]byte("pmessage"), []byte("news.*"), []byte("news.sport"), []byte("goal")})
+	r := &redisDriver{
+		dial: testDialer(c),
+	}
+
+	sub, err := r.PSubscribe("news.*")
+	if err != nil {
+		t.Fatal("No error was expected to psubscribe, but: ", err)
+	}
+	defer sub.Close()
+	m := receiveMessage(t, sub)
+	if m.Channel != "news.sport" || m.Pattern != "news.*" || string(m.Data) != "goal" {
+		t.Error("Message was incorrect: ", m)
+	}
+}
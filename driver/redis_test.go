@@ -1,7 +1,9 @@
 package driver
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/rafaeljusto/redigomock"
@@ -367,3 +369,478 @@ func TestRedisHDecr(t *testing.T) {
 		t.Error("'Invalid delta' error was expected to HDecr, but: ", err)
 	}
 }
+
+func TestRedisXAdd(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+		test: true,
+	}
+
+	c.Command("XADD", "stream", "*", "a", 1, "b", "ok").Expect("1-0")
+
+	id, err := r.XAdd("stream", "*", map[string]interface{}{"b": "ok", "a": 1})
+	if err != nil {
+		t.Error("No error was expected to XAdd, but: ", err)
+	}
+	if id != "1-0" {
+		t.Error("XAdd id was expected to '1-0', but: ", id)
+	}
+}
+
+func TestRedisXRange(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("XRANGE", "stream", "-", "+", "COUNT", int64(2)).Expect([]interface{}{
+		[]interface{}{[]byte("1-0"), []interface{}{[]byte("a"), []byte("1")}},
+		[]interface{}{[]byte("2-0"), []interface{}{[]byte("b"), []byte("ok")}},
+	})
+
+	msgs, err := r.XRange("stream", "-", "+", 2)
+	if err != nil {
+		t.Error("No error was expected to XRange, but: ", err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != "1-0" || msgs[0].Values["a"] != "1" || msgs[1].ID != "2-0" || msgs[1].Values["b"] != "ok" {
+		t.Error("XRange result was incorrect: ", msgs)
+	}
+}
+
+func TestRedisXRead(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("XREAD", "COUNT", int64(10), "BLOCK", int64(250), "STREAMS", "s1", "s2", "0", "$").Expect([]interface{}{
+		[]interface{}{[]byte("s1"), []interface{}{
+			[]interface{}{[]byte("1-0"), []interface{}{[]byte("a"), []byte("1")}},
+		}},
+	})
+	c.Command("XREAD", "BLOCK", int64(1), "STREAMS", "s1", "$").Expect(nil)
+
+	ss, err := r.XRead(map[string]string{"s2": "$", "s1": "0"}, 10, 250*time.Millisecond)
+	if err != nil {
+		t.Error("No error was expected to XRead, but: ", err)
+	}
+	if len(ss) != 1 || ss[0].Key != "s1" || len(ss[0].Messages) != 1 || ss[0].Messages[0].Values["a"] != "1" {
+		t.Error("XRead result was incorrect: ", ss)
+	}
+
+	ss, err = r.XRead(map[string]string{"s1": "$"}, 0, time.Microsecond)
+	if err != nil {
+		t.Error("No error was expected to XRead timeout, but: ", err)
+	}
+	if len(ss) != 0 {
+		t.Error("XRead result was expected to be empty on timeout, but: ", ss)
+	}
+}
+
+func TestRedisXGroupCreate(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("XGROUP", "CREATE", "stream", "group", "$", "MKSTREAM").Expect("OK")
+
+	err := r.XGroupCreate("stream", "group", "$", true)
+	if err != nil {
+		t.Error("No error was expected to XGroupCreate, but: ", err)
+	}
+}
+
+func TestRedisXReadGroup(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("XREADGROUP", "GROUP", "group", "consumer", "COUNT", int64(1), "STREAMS", "stream", ">").Expect([]interface{}{
+		[]interface{}{[]byte("stream"), []interface{}{
+			[]interface{}{[]byte("1-0"), []interface{}{[]byte("a"), []byte("1")}},
+			[]interface{}{[]byte("2-0"), nil},
+		}},
+	})
+
+	ss, err := r.XReadGroup("group", "consumer", map[string]string{"stream": ">"}, 1, 0)
+	if err != nil {
+		t.Error("No error was expected to XReadGroup, but: ", err)
+	}
+	if len(ss) != 1 || len(ss[0].Messages) != 2 || ss[0].Messages[0].ID != "1-0" || len(ss[0].Messages[1].Values) != 0 {
+		t.Error("XReadGroup result was incorrect: ", ss)
+	}
+}
+
+func TestRedisXAck(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("XACK", "stream", "group", "1-0", "2-0").Expect(int64(2))
+
+	n, err := r.XAck("stream", "group", "1-0", "2-0")
+	if err != nil {
+		t.Error("No error was expected to XAck, but: ", err)
+	}
+	if n != 2 {
+		t.Error("XAck count was expected to 2, but: ", n)
+	}
+}
+
+func TestRedisXPending(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("XPENDING", "stream", "group", "-", "+", int64(10), "consumer").Expect([]interface{}{
+		[]interface{}{[]byte("1-0"), []byte("consumer"), int64(1500), int64(2)},
+	})
+
+	pms, err := r.XPending("stream", "group", "-", "+", 10, "consumer")
+	if err != nil {
+		t.Error("No error was expected to XPending, but: ", err)
+	}
+	if len(pms) != 1 || pms[0].ID != "1-0" || pms[0].Consumer != "consumer" || pms[0].Idle != 1500*time.Millisecond || pms[0].Deliveries != 2 {
+		t.Error("XPending result was incorrect: ", pms)
+	}
+}
+
+func TestRedisXClaim(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("XCLAIM", "stream", "group", "consumer", int64(60000), "1-0").Expect([]interface{}{
+		[]interface{}{[]byte("1-0"), []interface{}{[]byte("a"), []byte("1")}},
+	})
+
+	msgs, err := r.XClaim("stream", "group", "consumer", time.Minute, "1-0")
+	if err != nil {
+		t.Error("No error was expected to XClaim, but: ", err)
+	}
+	if len(msgs) != 1 || msgs[0].ID != "1-0" || msgs[0].Values["a"] != "1" {
+		t.Error("XClaim result was incorrect: ", msgs)
+	}
+}
+
+func TestRedisEval(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+	s := NewScript(1, "return redis.call('INCRBY', KEYS[1], ARGV[1])")
+
+	load := c.Command("SCRIPT", "LOAD", s.Source()).Expect(s.Hash())
+	c.Command("EVALSHA", s.Hash(), 1, "counter", 2).Expect(int64(2)).Expect(int64(4))
+
+	v, err := r.Eval(s, "counter", 2)
+	if err != nil {
+		t.Error("No error was expected to Eval, but: ", err)
+	}
+	if v != int64(2) {
+		t.Error("Eval result was expected to 2, but: ", v)
+	}
+	v, err = r.Eval(s, "counter", 2)
+	if err != nil {
+		t.Error("No error was expected to Eval, but: ", err)
+	}
+	if v != int64(4) {
+		t.Error("Eval result was expected to 4, but: ", v)
+	}
+	if c.Stats(load) != 1 {
+		t.Error("Script was expected to be loaded once, but: ", c.Stats(load))
+	}
+}
+
+func TestRedisEvalNoScript(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+	s := NewScript(1, "return redis.call('GET', KEYS[1])")
+	r.setScriptLoaded(s.Hash(), true)
+
+	c.Command("EVALSHA", s.Hash(), 1, "test").ExpectError(redis.Error("NOSCRIPT No matching script. Please use EVAL."))
+	c.Command("EVAL", s.Source(), 1, "test").Expect("ok")
+
+	v, err := r.Eval(s, "test")
+	if err != nil {
+		t.Error("No error was expected to Eval, but: ", err)
+	}
+	if v != "ok" {
+		t.Error("Eval result was expected to 'ok', but: ", v)
+	}
+	if !r.scriptLoaded(s.Hash()) {
+		t.Error("Script should be marked loaded after EVAL")
+	}
+}
+
+func TestRedisEvalSha(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("EVALSHA", "abc", 2, "k1", "k2", "v").Expect("ok")
+
+	v, err := r.EvalSha("abc", 2, "k1", "k2", "v")
+	if err != nil {
+		t.Error("No error was expected to EvalSha, but: ", err)
+	}
+	if v != "ok" {
+		t.Error("EvalSha result was expected to 'ok', but: ", v)
+	}
+}
+
+func TestRedisPExpire(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("PEXPIRE", "test1", int64(250)).Expect(int64(1))
+
+	err := r.PExpire("test1", 250)
+	if err != nil {
+		t.Error("No error was expected to pexpire, but: ", err)
+	}
+}
+
+func TestRedisExpireDuration(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("PEXPIRE", "test1", int64(250)).Expect(int64(1))
+	c.Command("PEXPIRE", "test2", int64(1)).Expect(int64(1))
+
+	err := r.ExpireDuration("test1", 250*time.Millisecond)
+	if err != nil {
+		t.Error("No error was expected to expire duration, but: ", err)
+	}
+	// sub-millisecond duration must not become a zero expiration
+	err = r.ExpireDuration("test2", time.Microsecond)
+	if err != nil {
+		t.Error("No error was expected to expire duration, but: ", err)
+	}
+}
+
+func TestRedisExpireAt(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+	tm := time.Unix(1600000000, 250*int64(time.Millisecond))
+
+	c.Command("PEXPIREAT", "test1", int64(1600000000250)).Expect(int64(1))
+
+	err := r.ExpireAt("test1", tm)
+	if err != nil {
+		t.Error("No error was expected to expire at, but: ", err)
+	}
+}
+
+func TestRedisTTL(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("PTTL", "test1").Expect(int64(1500))
+	c.Command("PTTL", "test2").Expect(int64(-1))
+	c.Command("PTTL", "test3").Expect(int64(-2))
+
+	if ttl, err := r.TTL("test1"); err != nil || ttl != 1500*time.Millisecond {
+		t.Error("TTL return value incorrect: ", ttl, err)
+	}
+	if ttl, err := r.TTL("test2"); err != nil || ttl != -1 {
+		t.Error("TTL without expiration was expected to be -1, but: ", ttl, err)
+	}
+	if _, err := r.TTL("test3"); err != ErrValueNil {
+		t.Error("ErrValueNil was expected to TTL of missing key, but: ", err)
+	}
+}
+
+func TestRedisHExpire(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("HEXPIRE", "test1", int64(60), "FIELDS", 2, "k1", "k2").Expect([]interface{}{int64(1), int64(1)})
+	c.Command("HPEXPIRE", "test1", int64(250), "FIELDS", 1, "k1").Expect([]interface{}{int64(1)})
+
+	err := r.HExpire("test1", 60, "k1", "k2")
+	if err != nil {
+		t.Error("No error was expected to HExpire, but: ", err)
+	}
+	err = r.HPExpire("test1", 250, "k1")
+	if err != nil {
+		t.Error("No error was expected to HPExpire, but: ", err)
+	}
+}
+
+func TestRedisHTTL(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("HPTTL", "test1", "FIELDS", 3, "k1", "k2", "k3").Expect([]interface{}{int64(1500), int64(-1), int64(-2)})
+
+	m, err := r.HTTL("test1", "k1", "k2", "k3")
+	if err != nil {
+		t.Error("No error was expected to HTTL, but: ", err)
+	}
+	if len(m) != 2 || m["k1"] != 1500*time.Millisecond || m["k2"] != -1 {
+		t.Error("HTTL return value incorrect: ", m)
+	}
+}
+
+func TestRedisHPersist(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("HPERSIST", "test1", "FIELDS", 1, "k1").Expect([]interface{}{int64(1)})
+
+	err := r.HPersist("test1", "k1")
+	if err != nil {
+		t.Error("No error was expected to HPersist, but: ", err)
+	}
+}
+
+func TestRedisBatch(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+		test: true,
+	}
+
+	c.Command("MULTI").Expect("OK")
+	set := c.Command("SET", "test1", "ok").Expect("QUEUED")
+	hmset := c.Command("HMSET", "hash", "k1", 1, "k2", "v").Expect("QUEUED")
+	c.Command("EXEC").Expect([]interface{}{"OK", "OK"})
+
+	err := r.Batch(func(d Driver) error {
+		if err := d.Set("test1", "ok"); err != ErrQueued {
+			t.Error("ErrQueued was expected to Set inside batch, but: ", err)
+		}
+		if err := d.HMSet("hash", map[string]interface{}{"k2": "v", "k1": 1}); err != ErrQueued {
+			t.Error("ErrQueued was expected to HMSet inside batch, but: ", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error("No error was expected to batch, but: ", err)
+	}
+	if c.Stats(set) != 1 || c.Stats(hmset) != 1 {
+		t.Error("Queued commands were expected to be sent once")
+	}
+}
+
+func TestRedisBatchExecError(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("MULTI").Expect("OK")
+	c.Command("HSET", "test1", "k1", "v").Expect("QUEUED")
+	c.Command("EXEC").Expect([]interface{}{redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")})
+
+	err := r.Batch(func(d Driver) error {
+		d.HSet("test1", "k1", "v")
+		return nil
+	})
+	be, ok := err.(*BatchError)
+	if !ok {
+		t.Fatal("BatchError was expected to batch, but: ", err)
+	}
+	if _, ok := be.Errors[0].(redis.Error); !ok || len(be.Errors) != 1 {
+		t.Error("Batch error of the first command was expected, but: ", be.Errors)
+	}
+}
+
+func TestRedisBatchDiscard(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("MULTI").Expect("OK")
+	c.Command("SET", "test1", "ok").Expect("QUEUED")
+	discard := c.Command("DISCARD").Expect("OK")
+	exec := c.Command("EXEC").Expect([]interface{}{"OK"})
+
+	fnErr := errors.New("test")
+	err := r.Batch(func(d Driver) error {
+		d.Set("test1", "ok")
+		return fnErr
+	})
+	if err != fnErr {
+		t.Error("Error of batch func was expected, but: ", err)
+	}
+	if c.Stats(discard) != 1 || c.Stats(exec) != 0 {
+		t.Error("Batch was expected to be discarded")
+	}
+}
+
+func TestRedisWatch(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	watch := c.Command("WATCH", "test1", "test2").Expect("OK")
+	c.Command("MULTI").Expect("OK")
+	c.Command("SET", "test1", "ok").Expect("QUEUED")
+	c.Command("EXEC").Expect(nil)
+
+	s, err := r.Watch("test1", "test2")
+	if err != nil {
+		t.Fatal("No error was expected to watch, but: ", err)
+	}
+	defer s.Close()
+	err = s.Batch(func(d Driver) error {
+		d.Set("test1", "ok")
+		return nil
+	})
+	if err != ErrConflict {
+		t.Error("ErrConflict was expected to batch of changed watched keys, but: ", err)
+	}
+	if c.Stats(watch) != 1 {
+		t.Error("Keys were expected to be watched once")
+	}
+}
+
+func TestRedisWatchNoWrites(t *testing.T) {
+	c := redigomock.NewConn()
+	r := &redisDriver{
+		pool: &testRedisPool{conn: c},
+	}
+
+	c.Command("WATCH", "test1").Expect("OK")
+	c.Command("MULTI").Expect("OK")
+	exec := c.Command("EXEC").Expect(nil)
+
+	s, err := r.Watch("test1")
+	if err != nil {
+		t.Fatal("No error was expected to watch, but: ", err)
+	}
+	defer s.Close()
+	if err := s.Batch(func(d Driver) error { return nil }); err != ErrConflict {
+		t.Error("ErrConflict was expected to empty batch of changed watched keys, but: ", err)
+	}
+	if c.Stats(exec) != 1 {
+		t.Error("Empty batch was expected to be executed")
+	}
+}
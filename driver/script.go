@@ -0,0 +1,72 @@
+package driver
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+)
+
+// Script Lua script executed on server side
+type Script struct {
+	keyCount int
+	src      string
+	hash     string
+}
+
+// NewScript create script, the first keyCount arguments passed to Eval are keys, negative means none
+func NewScript(keyCount int, src string) *Script {
+	if keyCount < 0 {
+		keyCount = 0
+	}
+	h := sha1.Sum([]byte(src))
+	return &Script{
+		keyCount: keyCount,
+		src:      src,
+		hash:     hex.EncodeToString(h[:]),
+	}
+}
+
+// KeyCount get number of keys
+func (s *Script) KeyCount() int {
+	return s.keyCount
+}
+
+// Source get script source
+func (s *Script) Source() string {
+	return s.src
+}
+
+// Hash get SHA1 hash of script source
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// Keys get keys from arguments passed to Eval
+func (s *Script) Keys(keysAndArgs ...interface{}) []string {
+	return ScriptKeys(s.keyCount, keysAndArgs...)
+}
+
+// ScriptKeys get the first keyCount arguments of script as keys, converted to strings as
+// they are sent to the server
+func ScriptKeys(keyCount int, keysAndArgs ...interface{}) []string {
+	if keyCount > len(keysAndArgs) {
+		keyCount = len(keysAndArgs)
+	}
+	if keyCount < 0 {
+		keyCount = 0
+	}
+	keys := make([]string, 0, keyCount)
+	for _, k := range keysAndArgs[:keyCount] {
+		switch x := k.(type) {
+		case string:
+			keys = append(keys, x)
+		case []byte:
+			keys = append(keys, string(x))
+		case fmt.Stringer:
+			keys = append(keys, x.String())
+		default:
+			keys = append(keys, fmt.Sprint(x))
+		}
+	}
+	return keys
+}
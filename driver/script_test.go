@@ -0,0 +1,23 @@
+package driver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScriptKeys(t *testing.T) {
+	if keys := ScriptKeys(-1, "a", "b"); len(keys) != 0 {
+		t.Error("No keys were expected for negative key count, but: ", keys)
+	}
+	if s := NewScript(-1, "return 1"); s.KeyCount() != 0 || len(s.Keys("a")) != 0 {
+		t.Error("Negative key count was expected to mean no keys, but: ", s.KeyCount())
+	}
+	keys := ScriptKeys(4, "a", []byte("b"), time.Second, 7, "arg")
+	if !reflect.DeepEqual(keys, []string{"a", "b", "1s", "7"}) {
+		t.Error("Keys were expected to be converted to strings, but: ", keys)
+	}
+	if keys := ScriptKeys(3, "a"); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Error("Key count was expected to be bounded by arguments, but: ", keys)
+	}
+}
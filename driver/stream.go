@@ -0,0 +1,23 @@
+package driver
+
+import "time"
+
+// StreamMessage message stored in a stream
+type StreamMessage struct {
+	ID     string            // message id
+	Values map[string]string // message field-value pairs
+}
+
+// Stream messages read from a stream key
+type Stream struct {
+	Key      string          // stream key
+	Messages []StreamMessage // messages read
+}
+
+// PendingMessage message delivered to a consumer but not acknowledged yet
+type PendingMessage struct {
+	ID         string        // message id
+	Consumer   string        // consumer owning the message
+	Idle       time.Duration // time elapsed since the last delivery
+	Deliveries int64         // number of deliveries
+}
@@ -3,14 +3,107 @@ package cache
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var (
 	// ErrValueNil cache value is nil, indicates that key not exist
 	ErrValueNil = errors.New("cache: value nil")
+
+	// ErrNotApplied command was not applied because commit stopped at a previous failure
+	ErrNotApplied = errors.New("cache: command not applied")
+
+	// ErrConflict watched keys changed before transaction commit
+	ErrConflict = errors.New("cache: transaction conflict")
+
+	// ErrTransactionExpired transaction was rolled back because its deadline passed or its context is done
+	ErrTransactionExpired = errors.New("cache: transaction expired")
+
+	// ErrLocked lock of key is held by another transaction
+	ErrLocked = errors.New("cache: key locked")
+
+	// ErrTransactionClosed transaction was already committed or rolled back
+	ErrTransactionClosed = errors.New("cache: transaction closed")
+
+	// ErrTransactionCommitting transaction cannot be changed, committed or rolled back while its OnCommit callbacks run
+	ErrTransactionCommitting = errors.New("cache: transaction committing")
+
+	// ErrNoMarker commands of journal entry without transaction ID are not applied again, they may have been applied already
+	ErrNoMarker = errors.New("cache: no transaction marker")
+
+	// ErrNotEnlisted transaction cannot be enlisted in coordinator, it is not active, nested or the coordinator is prepared
+	ErrNotEnlisted = errors.New("cache: transaction not enlisted")
 )
 
 // InternalError generate interfanl error
 func InternalError(err error) error {
 	return fmt.Errorf("cache: internal error (%s)", err)
 }
+
+// CommandError failed command of transaction commit
+type CommandError struct {
+	Op   string        // operation, e.g. "Set", "HMSet"
+	Key  string        // key of command, empty for multiple keys command
+	Args []interface{} // arguments of command
+	Err  error         // underlying error
+
+	cmd *command
+}
+
+func (e *CommandError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s: %s", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Op, e.Key, e.Err)
+}
+
+// CommitError error of transaction commit, holds every failed command in order
+type CommitError struct {
+	Failed []*CommandError
+
+	Compensated      bool    // applied commands were restored, only with CompensateOnError
+	CompensateErrors []error // errors of restoring applied commands
+}
+
+func (e *CommitError) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for i, f := range e.Failed {
+		msgs[i] = f.Error()
+	}
+	msg := fmt.Sprintf("cache: commit failed (%s)", strings.Join(msgs, "; "))
+	if e.Compensated {
+		return msg + ", compensated"
+	}
+	if len(e.CompensateErrors) > 0 {
+		msgs = make([]string, len(e.CompensateErrors))
+		for i, err := range e.CompensateErrors {
+			msgs[i] = err.Error()
+		}
+		msg += fmt.Sprintf(", compensation failed (%s)", strings.Join(msgs, "; "))
+	}
+	return msg
+}
+
+// CoordinatorError error of coordinator commit
+type CoordinatorError struct {
+	Participant string // name of failed participant, empty if the outcome could not be recorded
+	Err         error  // underlying error
+
+	Compensated      bool    // applied transactions were restored, only for failures after prepare
+	CompensateErrors []error // errors of restoring applied transactions
+}
+
+func (e *CoordinatorError) Error() string {
+	msg := fmt.Sprintf("cache: coordinator failed (%s: %s)", e.Participant, e.Err)
+	if e.Participant == "" {
+		msg = fmt.Sprintf("cache: coordinator failed (%s)", e.Err)
+	}
+	if len(e.CompensateErrors) > 0 {
+		msgs := make([]string, len(e.CompensateErrors))
+		for i, err := range e.CompensateErrors {
+			msgs[i] = err.Error()
+		}
+		msg += fmt.Sprintf(", compensation failed (%s)", strings.Join(msgs, "; "))
+	}
+	return msg
+}
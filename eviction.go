@@ -0,0 +1,271 @@
+package cache
+
+import (
+	"container/list"
+	"hash/fnv"
+)
+
+// EvictionPolicy policy of keys evicted when memory is full
+type EvictionPolicy int
+
+const (
+	// LRU evict least recently used keys
+	LRU EvictionPolicy = iota
+
+	// LFU evict least frequently used keys, among the least recently used ones
+	LFU
+
+	// TinyLFU evict least recently used keys, a new key is only kept if it was
+	// requested more often than the key it would evict
+	TinyLFU
+)
+
+const (
+	lfuSamples   = 5  // least recently used keys compared by LFU
+	sketchRows   = 4  // rows of frequency sketch
+	sketchMax    = 15 // saturation of frequency sketch counters
+	sketchWidth  = 1024
+	sketchPeriod = 10 // counters are halved after width * period additions
+)
+
+// MemoryStats counters of memory
+type MemoryStats struct {
+	Entries   int   // keys in memory
+	Bytes     int64 // estimated size of keys in memory, counted with MaxBytes option only
+	Evictions int64 // keys evicted to stay within MaxEntries and MaxBytes
+	Rejected  int64 // new keys not kept by TinyLFU
+}
+
+// entry key in memory tracked for eviction
+type entry struct {
+	key  string
+	size int64
+	freq uint32
+	elem *list.Element
+}
+
+// MemoryStats get counters of memory
+func (c *cacheImpl) MemoryStats() MemoryStats {
+	st := MemoryStats{}
+	for _, s := range c.shards {
+		s.Lock()
+		st.Entries += len(s.entries)
+		st.Bytes += s.bytes
+		st.Evictions += s.evictions
+		st.Rejected += s.rejected
+		s.Unlock()
+	}
+	return st
+}
+
+// access record request of key
+func (s *shard) access(key string) {
+	if s.sketch != nil {
+		s.sketch.add(key)
+	}
+	if e, ok := s.entries[key]; ok {
+		e.freq++
+		s.recent.MoveToFront(e.elem)
+	}
+}
+
+// track account key changed in memory and evict keys over limits, keys pinned are always admitted
+func (s *shard) track(key string) {
+	size, ok := s.size(key)
+	if !ok {
+		s.untrack(key)
+		return
+	}
+	e, tracked := s.entries[key]
+	if !tracked {
+		if s.sketch != nil && s.pinned[key] == 0 && s.full(size) {
+			if v := s.victim(key); v != nil && s.sketch.estimate(key) <= s.sketch.estimate(v.key) {
+				s.drop(key)
+				s.rejected++
+				return
+			}
+		}
+		e = &entry{key: key}
+		e.elem = s.recent.PushFront(e)
+		s.entries[key] = e
+	} else {
+		s.recent.MoveToFront(e.elem)
+	}
+	e.freq++
+	s.bytes += size - e.size
+	e.size = size
+	s.evict(key)
+}
+
+// untrack forget key dropped from memory
+func (s *shard) untrack(key string) {
+	if e, ok := s.entries[key]; ok {
+		s.recent.Remove(e.elem)
+		s.bytes -= e.size
+		delete(s.entries, key)
+	}
+}
+
+// size get estimated size of key in memory, ok is false if not in memory
+func (s *shard) size(key string) (n int64, ok bool) {
+	v, kok := s.keys[key]
+	m, hok := s.hsets[key]
+	ex, eok := s.hexpires[key]
+	_, dok := s.delKeys[key]
+	if !kok && !hok && !eok && !dok {
+		return 0, false
+	}
+	if s.maxBytes == 0 {
+		return 0, true
+	}
+	n = int64(len(key) + len(v))
+	for hk, hv := range m {
+		n += int64(len(hk) + len(hv))
+	}
+	for hk := range ex {
+		n += int64(len(hk)) + 24 // size of time.Time
+	}
+	return n, true
+}
+
+// full check if a new key of size does not fit in memory
+func (s *shard) full(size int64) bool {
+	return (s.maxEntries > 0 && len(s.entries) >= s.maxEntries) || (s.maxBytes > 0 && s.bytes+size > s.maxBytes)
+}
+
+// over check if memory is over limits
+func (s *shard) over() bool {
+	return (s.maxEntries > 0 && len(s.entries) > s.maxEntries) || (s.maxBytes > 0 && s.bytes > s.maxBytes)
+}
+
+// evict drop keys while memory is over limits, key just changed is dropped last
+// and keys pinned are never dropped
+func (s *shard) evict(key string) {
+	for s.over() {
+		v := s.victim(key)
+		if v == nil {
+			if s.pinned[key] == 0 {
+				s.drop(key)
+				s.evictions++
+			}
+			return
+		}
+		s.drop(v.key)
+		s.evictions++
+	}
+}
+
+// victim get key to evict other than key, the least recently used one not pinned,
+// with LFU the least frequently used among lfuSamples of them. Nil if none.
+func (s *shard) victim(key string) *entry {
+	var v *entry
+	n := 0
+	for el := s.recent.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*entry)
+		if e.key == key || s.pinned[e.key] > 0 {
+			continue
+		}
+		if v == nil || e.freq < v.freq {
+			v = e
+		}
+		if n++; s.policy != LFU || n >= lfuSamples {
+			break
+		}
+	}
+	return v
+}
+
+// pinMemory keep key in memory until the outermost transaction ends, if memory is limited
+func (t *transImpl) pinMemory(key string) {
+	r := t.root()
+	s := t.c.shard(key)
+	if r.pinned[key] || !s.bounded() {
+		return
+	}
+	if r.pinned == nil {
+		r.pinned = map[string]bool{}
+	}
+	r.pinned[key] = true
+	s.Lock()
+	s.pinned[key]++
+	s.Unlock()
+}
+
+// unpinMemory release keys pinned by transaction
+func (t *transImpl) unpinMemory() {
+	for k := range t.pinned {
+		s := t.c.shard(k)
+		s.Lock()
+		if s.pinned[k]--; s.pinned[k] <= 0 {
+			delete(s.pinned, k)
+		}
+		s.Unlock()
+	}
+	t.pinned = nil
+}
+
+// sketch count-min sketch estimating frequency of keys requested, counters are halved
+// periodically so that keys requested long ago are forgotten
+type sketch struct {
+	rows  [sketchRows][]uint8
+	mask  uint64
+	adds  int
+	reset int
+}
+
+// newSketch create sketch for about n keys
+func newSketch(n int) *sketch {
+	w := sketchWidth
+	if n > 0 {
+		w = 16
+		for w < n*4 {
+			w *= 2
+		}
+	}
+	k := &sketch{mask: uint64(w - 1), reset: w * sketchPeriod}
+	for i := range k.rows {
+		k.rows[i] = make([]uint8, w)
+	}
+	return k
+}
+
+// indexes get counter of key in every row
+func (k *sketch) indexes(key string) [sketchRows]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	idx := [sketchRows]uint64{}
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) & k.mask
+	}
+	return idx
+}
+
+// add count request of key
+func (k *sketch) add(key string) {
+	for i, j := range k.indexes(key) {
+		if k.rows[i][j] < sketchMax {
+			k.rows[i][j]++
+		}
+	}
+	if k.adds++; k.adds >= k.reset {
+		for _, row := range k.rows {
+			for j := range row {
+				row[j] /= 2
+			}
+		}
+		k.adds /= 2
+	}
+}
+
+// estimate get estimated number of requests of key
+func (k *sketch) estimate(key string) uint8 {
+	n := uint8(sketchMax)
+	for i, j := range k.indexes(key) {
+		if k.rows[i][j] < n {
+			n = k.rows[i][j]
+		}
+	}
+	return n
+}
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	dmock "github.com/go-lego/cache/driver/mock"
+	"github.com/golang/mock/gomock"
+)
+
+// shardKeys get n keys of the same shard of c
+func shardKeys(c *cacheImpl, n int) []string {
+	keys := []string{}
+	s := c.shard("key0")
+	for i := 0; len(keys) < n; i++ {
+		if k := fmt.Sprintf("key%d", i); c.shard(k) == s {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func TestEvictionLRU(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), MaxEntries(2*shardCount))
+	k := shardKeys(c, 3)
+
+	d.EXPECT().Get(k[0]).Return("a", nil)
+	d.EXPECT().Get(k[1]).Return("b", nil)
+	d.EXPECT().Get(k[2]).Return("c", nil)
+	c.Get(k[0])
+	c.Get(k[1])
+	c.Get(k[0])
+	c.Get(k[2])
+	s := c.shard(k[0])
+	if _, ok := s.keys[k[1]]; ok || s.keys[k[0]] != "a" || s.keys[k[2]] != "c" {
+		t.Error("Least recently used key was expected to be evicted, but: ", s.keys)
+	}
+	if st := c.MemoryStats(); st.Entries != 2 || st.Evictions != 1 {
+		t.Error("MemoryStats incorrect: ", st)
+	}
+}
+
+func TestEvictionLFU(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), MaxEntries(2*shardCount), Eviction(LFU))
+	k := shardKeys(c, 3)
+
+	d.EXPECT().Get(k[0]).Return("a", nil)
+	d.EXPECT().Get(k[1]).Return("b", nil)
+	d.EXPECT().Get(k[2]).Return("c", nil)
+	for i := 0; i < 3; i++ {
+		c.Get(k[0])
+	}
+	c.Get(k[1])
+	c.Get(k[2])
+	s := c.shard(k[0])
+	if _, ok := s.keys[k[1]]; ok || s.keys[k[0]] != "a" {
+		t.Error("Least frequently used key was expected to be evicted, but: ", s.keys)
+	}
+}
+
+func TestEvictionTinyLFU(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), MaxEntries(shardCount), Eviction(TinyLFU))
+	k := shardKeys(c, 2)
+
+	d.EXPECT().Get(k[0]).Return("a", nil)
+	d.EXPECT().Get(k[1]).Return("b", nil).Times(3)
+	c.Get(k[0])
+	c.Get(k[0])
+	c.Get(k[1])
+	c.Get(k[1])
+	if st := c.MemoryStats(); st.Rejected != 2 || st.Evictions != 0 || c.shard(k[0]).keys[k[0]] != "a" {
+		t.Error("Keys requested less often were expected to be rejected, but: ", st)
+	}
+	c.Get(k[1])
+	if st := c.MemoryStats(); st.Evictions != 1 || c.shard(k[1]).keys[k[1]] != "b" {
+		t.Error("Key requested more often was expected to be admitted, but: ", st)
+	}
+}
+
+func TestEvictionTinyLFUPinned(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), MaxEntries(shardCount), Eviction(TinyLFU))
+	k := shardKeys(c, 2)
+
+	d.EXPECT().Get(k[0]).Return("a", nil)
+	d.EXPECT().Get(k[1]).Return("b", nil)
+	c.Get(k[0])
+	c.Get(k[0])
+	tx := c.NewTransaction()
+	tx.Get(k[1])
+	if v, _ := tx.Get(k[1]); v != "b" || c.shard(k[1]).keys[k[1]] != "b" {
+		t.Error("Key used by active transaction was expected to be admitted, but: ", v)
+	}
+	if st := c.MemoryStats(); st.Rejected != 0 || st.Evictions != 1 {
+		t.Error("MemoryStats incorrect: ", st)
+	}
+	tx.Rollback()
+}
+
+func TestEvictionMaxBytes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), MaxBytes(20*shardCount))
+	k := shardKeys(c, 2)
+
+	d.EXPECT().Set(k[0], "0123456789").Return(nil)
+	d.EXPECT().Set(k[1], "0123456789").Return(nil)
+	c.Set(k[0], "0123456789")
+	c.Set(k[1], "0123456789")
+	if _, ok := c.shard(k[0]).keys[k[0]]; ok {
+		t.Error("Key was expected to be evicted over MaxBytes")
+	}
+	if st := c.MemoryStats(); st.Bytes != int64(len(k[1])+10) || st.Evictions != 1 {
+		t.Error("MemoryStats incorrect: ", st)
+	}
+}
+
+func TestEvictionLimitBelowShards(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), MaxEntries(10))
+
+	d.EXPECT().Set(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("key%d", i), i)
+	}
+	if st := c.MemoryStats(); st.Entries != 10 || st.Evictions != 90 {
+		t.Error("MaxEntries was expected to limit the whole memory, but: ", st)
+	}
+	c = newCacheImpl(Driver(d), MaxEntries(100))
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("key%d", i), i)
+	}
+	if st := c.MemoryStats(); st.Entries > 100 {
+		t.Error("MaxEntries was expected to limit the whole memory, but: ", st)
+	}
+}
+
+func TestEvictionPinned(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), MaxEntries(shardCount))
+	k := shardKeys(c, 2)
+
+	d.EXPECT().Get(k[0]).Return("a", nil)
+	d.EXPECT().Get(k[1]).Return("b", nil).Times(2)
+	tx := c.NewTransaction()
+	tx.Get(k[0])
+	c.Get(k[1])
+	s := c.shard(k[0])
+	if _, ok := s.keys[k[1]]; ok || s.keys[k[0]] != "a" {
+		t.Error("Key used by active transaction should not be evicted, but: ", s.keys)
+	}
+	tx.Rollback()
+	c.Get(k[1])
+	if _, ok := s.keys[k[0]]; ok || s.keys[k[1]] != "b" {
+		t.Error("Key was expected to be evicted after transaction ended, but: ", s.keys)
+	}
+	if st := c.MemoryStats(); st.Evictions != 2 {
+		t.Error("MemoryStats incorrect: ", st)
+	}
+}
@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/go-lego/cache/driver"
+)
+
+// kinds of keys changed by command
+const (
+	kindNone   = 0
+	kindString = 1
+	kindHash   = 2
+	kindAny    = 3 // string or hash
+)
+
+// kind get kind of keys changed by command
+func (cmd *command) kind() int {
+	switch cmd.t {
+	case typeSet, typeMSet, typeIncr, typeDecr:
+		return kindString
+	case typeHSet, typeHMSet, typeHDel, typeHExpire, typeHPExpire, typeHPersist, typeHIncr, typeHDecr:
+		return kindHash
+	case typeDel, typeExpire, typePExpire, typeExpireDuration, typeExpireAt:
+		return kindAny
+	}
+	return kindNone
+}
+
+// image before-image of key, captured before commit to restore the key if commit fails
+type image struct {
+	Key    string                   `json:"key"`
+	Exists bool                     `json:"exists"`
+	Value  string                   `json:"value,omitempty"` // value of string key
+	Hash   map[string]string        `json:"hash,omitempty"`  // value of hash key, nil for string key
+	TTL    time.Duration            `json:"ttl"`             // expiration of key, -1 if none
+	HTTLs  map[string]time.Duration `json:"httls,omitempty"` // expiration of hash keys
+
+	// values written by transaction, the image is only restored by recovery while the key
+	// still holds them. flagValueNil if deleted, empty and nil if unknown.
+	Written  string            `json:"written,omitempty"`
+	HWritten map[string]string `json:"hwritten,omitempty"`
+}
+
+// captureImages capture before-images of keys changed by write commands, in order of first change
+func (t *transImpl) captureImages() ([]*image, error) {
+	d := t.c.options.Driver
+	seen := map[string]bool{}
+	images := []*image{}
+	for _, cmd := range t.cmds {
+		kind := cmd.kind()
+		if !cmd.isWrite() || kind == kindNone {
+			continue
+		}
+		for _, k := range cmd.keys() {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			img, err := captureImage(d, k, kind)
+			if err != nil {
+				return nil, err
+			}
+			t.written(img)
+			images = append(images, img)
+		}
+	}
+	return images, nil
+}
+
+// captureImage capture before-image of key
+func captureImage(d driver.Driver, key string, kind int) (*image, error) {
+	img := &image{Key: key, TTL: -1}
+	if kind != kindHash {
+		v, err := d.Get(key)
+		switch {
+		case err == nil:
+			img.Exists, img.Value = true, v
+		case err == driver.ErrValueNil:
+			return img, nil
+		case kind == kindString:
+			return nil, err
+		default: // not a string
+			kind = kindHash
+		}
+	}
+	if kind == kindHash {
+		m, err := d.HGetAll(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(m) == 0 {
+			return img, nil
+		}
+		img.Exists, img.Hash = true, m
+		hks := make([]string, 0, len(m))
+		for hk := range m {
+			hks = append(hks, hk)
+		}
+		if ttls, err := d.HTTL(key, hks...); err == nil { // not supported by every server
+			img.HTTLs = ttls
+		}
+	}
+	ttl, err := d.TTL(key)
+	if err != nil && err != driver.ErrValueNil {
+		return nil, err
+	}
+	if err == nil {
+		img.TTL = ttl
+	}
+	return img, nil
+}
+
+// written record in img the values of its key written by transaction
+func (t *transImpl) written(img *image) {
+	if v, ok := t.keys[img.Key]; ok {
+		img.Written = v
+	} else if m, ok := t.hsets[img.Key]; ok {
+		img.HWritten = make(map[string]string, len(m))
+		for hk, v := range m {
+			img.HWritten[hk] = v
+		}
+	} else if t.delKeys[img.Key] {
+		img.Written = flagValueNil
+	}
+}
+
+// changed check if key no longer holds the values written by transaction, unknown values are taken as kept
+func (img *image) changed(d driver.Driver) (bool, error) {
+	switch {
+	case img.Written == flagValueNil:
+		exists, err := d.Exists(img.Key)
+		return exists, err
+	case img.Written != "":
+		v, err := d.Get(img.Key)
+		if err == driver.ErrValueNil {
+			return true, nil
+		}
+		return v != img.Written, err
+	case img.HWritten != nil:
+		m, err := d.HGetAll(img.Key)
+		if err != nil {
+			return false, err
+		}
+		for hk, w := range img.HWritten {
+			v, ok := m[hk]
+			if !ok {
+				v = flagValueNil
+			}
+			if v != w {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// restore write before-image back to driver
+func (img *image) restore(d driver.Driver) error {
+	if err := d.Del(img.Key); err != nil || !img.Exists {
+		return err
+	}
+	if img.Hash != nil {
+		kvs := make(map[string]interface{}, len(img.Hash))
+		for hk, v := range img.Hash {
+			kvs[hk] = v
+		}
+		if err := d.HMSet(img.Key, kvs); err != nil {
+			return err
+		}
+		for hk, ttl := range img.HTTLs {
+			if ttl > 0 {
+				if err := d.HPExpire(img.Key, millis(ttl), hk); err != nil {
+					return err
+				}
+			}
+		}
+	} else if err := d.Set(img.Key, img.Value); err != nil {
+		return err
+	}
+	if img.TTL > 0 {
+		return d.PExpire(img.Key, millis(img.TTL))
+	}
+	return nil
+}
+
+// restoreImages restore keys changed by applied commands in reverse order, failed commands were not applied
+func (t *transImpl) restoreImages(images []*image, failed []*CommandError) []error {
+	notApplied := map[*command]bool{}
+	for _, ce := range failed {
+		notApplied[ce.cmd] = true
+	}
+	changed := map[string]bool{}
+	for _, cmd := range t.cmds {
+		if cmd.isWrite() && !notApplied[cmd] {
+			for _, k := range cmd.keys() {
+				changed[k] = true
+			}
+		}
+	}
+	errs := []error{}
+	for i := len(images) - 1; i >= 0; i-- {
+		if img := images[i]; changed[img.Key] {
+			if err := img.restore(t.c.options.Driver); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+// millis convert d to milliseconds, at least 1
+func millis(d time.Duration) int64 {
+	if ms := int64(d / time.Millisecond); ms > 0 {
+		return ms
+	}
+	return 1
+}
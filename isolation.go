@@ -0,0 +1,179 @@
+package cache
+
+import "github.com/go-lego/cache/driver"
+
+// IsolationLevel isolation of reads inside transaction
+type IsolationLevel int
+
+const (
+	// ReadCommitted reads return the latest values known by the cache or the driver
+	ReadCommitted IsolationLevel = iota
+
+	// RepeatableRead values of keys and hash keys read by Get, MGet, HGet, HMGet, HExists and
+	// HGetAll are pinned to the first read from the driver, the keys are watched and commit
+	// fails with ErrConflict if any pinned value changed on the driver
+	RepeatableRead
+)
+
+// root get outermost transaction
+func (t *transImpl) root() *transImpl {
+	r := t
+	for r.parent != nil {
+		r = r.parent
+	}
+	return r
+}
+
+// repeatable check if reads of transaction are pinned
+func (t *transImpl) repeatable() bool {
+	return t.root().options.Isolation == RepeatableRead
+}
+
+// pinKey get value of key pinned by the first read, flagValueNil if not exist
+func (t *transImpl) pinKey(key string) (string, error) {
+	r := t.root()
+	if v, ok := r.pins[key]; ok {
+		return v, nil
+	}
+	if err := r.watch(key); err != nil {
+		return "", err
+	}
+	var v string
+	var err error
+	t.unlocked(func() { v, err = t.c.options.Driver.Get(key) })
+	if err == driver.ErrValueNil {
+		v, err = flagValueNil, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if pv, ok := r.pins[key]; ok { // pinned meanwhile
+		return pv, nil
+	}
+	r.pins[key] = v
+	return v, nil
+}
+
+// pinHashKey get value of hash key pinned by the first read, flagValueNil if not exist
+func (t *transImpl) pinHashKey(key string, hk string) (string, error) {
+	r := t.root()
+	if v, ok := r.hpins[key][hk]; ok {
+		return v, nil
+	}
+	if r.hfull[key] { // not in hash when pinned
+		return flagValueNil, nil
+	}
+	if err := r.watch(key); err != nil {
+		return "", err
+	}
+	var v string
+	var err error
+	t.unlocked(func() { v, err = t.c.options.Driver.HGet(key, hk) })
+	if err == driver.ErrValueNil {
+		v, err = flagValueNil, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if pv, ok := r.hpins[key][hk]; ok { // pinned meanwhile
+		return pv, nil
+	}
+	if r.hfull[key] {
+		return flagValueNil, nil
+	}
+	if _, ok := r.hpins[key]; !ok {
+		r.hpins[key] = map[string]string{}
+	}
+	r.hpins[key][hk] = v
+	return v, nil
+}
+
+// pinHash get all hash keys of key pinned by the first read
+func (t *transImpl) pinHash(key string) (map[string]string, error) {
+	r := t.root()
+	var m map[string]string
+	if !r.hfull[key] {
+		if err := r.watch(key); err != nil {
+			return nil, err
+		}
+		var err error
+		t.unlocked(func() { m, err = t.c.options.Driver.HGetAll(key) })
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !r.hfull[key] { // not pinned meanwhile
+		pins := map[string]string{}
+		for hk, v := range r.hpins[key] { // hash keys read before keep their pinned values
+			if _, ok := m[hk]; !ok && v != flagValueNil {
+				pins[hk] = v
+			}
+		}
+		for hk, v := range m {
+			if pv, ok := r.hpins[key][hk]; ok {
+				v = pv
+			}
+			pins[hk] = v
+		}
+		r.hpins[key] = pins
+		r.hfull[key] = true
+	}
+	ret := map[string]string{}
+	for hk, v := range r.hpins[key] {
+		if v != flagValueNil {
+			ret[hk] = v
+		}
+	}
+	return ret, nil
+}
+
+// readsChanged check if values pinned by reads changed on the driver, or cannot be read
+func (t *transImpl) readsChanged() bool {
+	d := t.c.options.Driver
+	for k, v := range t.pins {
+		cur, err := d.Get(k)
+		if err == driver.ErrValueNil {
+			cur, err = flagValueNil, nil
+		}
+		if err != nil || cur != v {
+			return true
+		}
+	}
+	for k, pins := range t.hpins {
+		if t.hfull[k] {
+			m, err := d.HGetAll(k)
+			if err != nil || !samePins(pins, m) {
+				return true
+			}
+			continue
+		}
+		for hk, v := range pins {
+			cur, err := d.HGet(k, hk)
+			if err == driver.ErrValueNil {
+				cur, err = flagValueNil, nil
+			}
+			if err != nil || cur != v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// samePins check if hash m holds exactly the pinned hash keys
+func samePins(pins map[string]string, m map[string]string) bool {
+	n := 0
+	for hk, v := range pins {
+		if v == flagValueNil {
+			if _, ok := m[hk]; ok {
+				return false
+			}
+			continue
+		}
+		if m[hk] != v {
+			return false
+		}
+		n++
+	}
+	return n == len(m)
+}
@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-lego/cache/driver"
+)
+
+// Journal durable log of transaction commits, an entry is written before the commands
+// are applied and removed after, entries left by a crash are recovered by Cache.Init.
+// Entries whose transaction marker was written are complete and only removed, entries without
+// transaction ID are only compensated, their commands are never applied again.
+type Journal interface {
+	// Write save entry data of id
+	Write(id string, data []byte) error
+
+	// Remove drop entry of id
+	Remove(id string) error
+
+	// Entries get data of entries not removed, by id
+	Entries() (map[string][]byte, error)
+}
+
+// journalEntry entry of transaction commit in journal
+type journalEntry struct {
+	ID       string    `json:"id,omitempty"` // transaction ID, its marker is written with the commands
+	Time     time.Time `json:"time"`
+	Commands []Command `json:"commands"`
+	Images   []*image  `json:"images,omitempty"` // before-images, the entry is compensated instead of applied again
+	Done     int       `json:"done,omitempty"`   // commands already run when applied one by one, not applied again
+}
+
+const journalMarkerTTL = 24 * time.Hour // retention of markers of journaled or coordinated commits without MarkerTTL
+
+// newID generate random id
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeJournal write journal entry of write commands before commit, returns empty id if nothing to write
+func (t *transImpl) writeJournal(images []*image) (string, *journalEntry, error) {
+	j := t.c.options.Journal
+	if j == nil {
+		return "", nil, nil
+	}
+	e := &journalEntry{Time: time.Now(), Commands: t.pending(), Images: images}
+	if len(e.Commands) == 0 {
+		return "", nil, nil
+	}
+	if t.marked {
+		e.ID = t.id
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", nil, err
+	}
+	id := newID()
+	return id, e, j.Write(id, data)
+}
+
+// advanceJournal record n commands of entry run, so that recovery does not apply them again
+func (c *cacheImpl) advanceJournal(id string, e *journalEntry, n int) {
+	if id == "" {
+		return
+	}
+	e.Done = n
+	if data, err := json.Marshal(e); err == nil {
+		c.options.Journal.Write(id, data)
+	}
+}
+
+// recoverJournal finish commits left in journal, entries of commits whose marker was written
+// are complete, others with before-images are compensated and the rest applied again
+func (c *cacheImpl) recoverJournal() error {
+	j := c.options.Journal
+	entries, err := j.Entries()
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(entries))
+	es := map[string]*journalEntry{}
+	errs := []string{}
+	for id, data := range entries {
+		e := &journalEntry{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(e); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", id, err))
+			continue
+		}
+		ids = append(ids, id)
+		es[id] = e
+	}
+	sort.Slice(ids, func(a, b int) bool { return es[ids[a]].Time.Before(es[ids[b]].Time) })
+	for _, id := range ids {
+		if err := c.recoverEntry(es[id]); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", id, err))
+			continue
+		}
+		j.Remove(id)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cache: journal recovery failed (%s)", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// recoverEntry compensate or apply again commands of entry, nothing is done if its marker was written.
+// Keys changed since the transaction wrote them are not restored. Commands are only applied again
+// if the entry has a transaction ID, without a marker to check they may have been applied already.
+func (c *cacheImpl) recoverEntry(e *journalEntry) error {
+	d := c.options.Driver
+	if e.ID != "" {
+		if applied, err := c.TransactionApplied(e.ID); err != nil || applied {
+			return err
+		}
+	}
+	if len(e.Images) > 0 {
+		for i := len(e.Images) - 1; i >= 0; i-- {
+			img := e.Images[i]
+			changed, err := img.changed(d)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				if err := img.restore(d); err != nil {
+					return err
+				}
+			}
+			c.Invalidate(img.Key)
+		}
+		return nil
+	}
+	if e.ID == "" && len(e.Commands) > e.Done {
+		return ErrNoMarker
+	}
+	for _, ec := range e.Commands[e.Done:] {
+		cmd, err := importCommand(ec)
+		if err != nil {
+			return err
+		}
+		if err := apply(d, cmd); err != nil {
+			return err
+		}
+		c.Invalidate(cmd.keys()...)
+	}
+	return nil
+}
+
+// fileJournal journal writing entries as files of directory
+type fileJournal struct {
+	dir string
+}
+
+// NewFileJournal create journal writing entries as files of directory dir
+func NewFileJournal(dir string) Journal {
+	return &fileJournal{dir: dir}
+}
+
+const journalExt = ".journal"
+
+// Write save entry as file, synced to disk before it is visible
+func (j *fileJournal) Write(id string, data []byte) error {
+	if err := os.MkdirAll(j.dir, 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(j.dir, id+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(j.dir, id+journalExt))
+}
+
+// Remove delete file of entry
+func (j *fileJournal) Remove(id string) error {
+	return os.Remove(filepath.Join(j.dir, id+journalExt))
+}
+
+// Entries read files of entries
+func (j *fileJournal) Entries() (map[string][]byte, error) {
+	entries := map[string][]byte{}
+	files, err := ioutil.ReadDir(j.dir)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), journalExt) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(j.dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entries[strings.TrimSuffix(f.Name(), journalExt)] = data
+	}
+	return entries, nil
+}
+
+// driverJournal journal writing entries as fields of a hash in driver
+type driverJournal struct {
+	d   driver.Driver
+	key string
+}
+
+// NewDriverJournal create journal writing entries as fields of hash key in driver d
+func NewDriverJournal(d driver.Driver, key string) Journal {
+	return &driverJournal{d: d, key: key}
+}
+
+// Write save entry as hash key
+func (j *driverJournal) Write(id string, data []byte) error {
+	return j.d.HSet(j.key, id, data)
+}
+
+// Remove delete hash key of entry
+func (j *driverJournal) Remove(id string) error {
+	return j.d.HDel(j.key, id)
+}
+
+// Entries get hash keys of entries
+func (j *driverJournal) Entries() (map[string][]byte, error) {
+	m, err := j.d.HGetAll(j.key)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string][]byte, len(m))
+	for id, data := range m {
+		entries[id] = []byte(data)
+	}
+	return entries, nil
+}
@@ -0,0 +1,273 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-lego/cache/driver"
+	dmock "github.com/go-lego/cache/driver/mock"
+	"github.com/golang/mock/gomock"
+)
+
+// memJournal journal in memory
+type memJournal struct {
+	entries map[string][]byte
+	writes  int
+}
+
+func (j *memJournal) Write(id string, data []byte) error {
+	j.writes++
+	j.entries[id] = data
+	return nil
+}
+
+func (j *memJournal) Remove(id string) error {
+	delete(j.entries, id)
+	return nil
+}
+
+func (j *memJournal) Entries() (map[string][]byte, error) {
+	return j.entries, nil
+}
+
+func TestFileJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	j := NewFileJournal(dir + "/tx")
+
+	if m, err := j.Entries(); err != nil || len(m) != 0 {
+		t.Error("No entries were expected before write, but: ", m, err)
+	}
+	if err := j.Write("id1", []byte("data1")); err != nil {
+		t.Error("No error was expected to write, but: ", err)
+	}
+	j.Write("id2", []byte("data2"))
+	if err := j.Remove("id1"); err != nil {
+		t.Error("No error was expected to remove, but: ", err)
+	}
+	m, err := j.Entries()
+	if err != nil || len(m) != 1 || string(m["id2"]) != "data2" {
+		t.Error("Entries return value incorrect: ", m, err)
+	}
+}
+
+func TestDriverJournal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	j := NewDriverJournal(d, "journal")
+
+	d.EXPECT().HSet("journal", "id1", []byte("data1")).Return(nil)
+	d.EXPECT().HDel("journal", "id1").Return(nil)
+	d.EXPECT().HGetAll("journal").Return(map[string]string{"id2": "data2"}, nil)
+
+	j.Write("id1", []byte("data1"))
+	j.Remove("id1")
+	if m, err := j.Entries(); err != nil || string(m["id2"]) != "data2" {
+		t.Error("Entries return value incorrect: ", m, err)
+	}
+}
+
+func TestTransCommitJournal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	j := &memJournal{entries: map[string][]byte{}}
+	c := newCacheImpl(Driver(d), WithJournal(j))
+
+	tx := c.BeginTransaction()
+	c.Set("test1", "ok")
+	c.HPersist("hash", "k1")
+
+	d.EXPECT().Set("test1", "ok").DoAndReturn(func(key string, value interface{}) error {
+		for _, data := range j.entries {
+			if !strings.Contains(string(data), `{"op":"Set","key":"test1","value":"ok"}`) {
+				t.Error("Journal entry was expected to hold the commands, but: ", string(data))
+			}
+			return nil
+		}
+		t.Error("Journal entry was expected to be written before commit")
+		return nil
+	})
+	d.EXPECT().HPersist("hash", "k1").Return(nil)
+	d.EXPECT().Set(markerKey(tx.ID()), "1").Return(nil)
+	d.EXPECT().PExpire(markerKey(tx.ID()), millis(journalMarkerTTL)).DoAndReturn(func(key string, ms int64) error {
+		for _, data := range j.entries {
+			if !strings.Contains(string(data), `"id":"`+tx.ID()+`"`) || !strings.Contains(string(data), `"done":3`) {
+				t.Error("Journal entry was expected to record commands run, but: ", string(data))
+			}
+		}
+		return nil
+	})
+	if err := tx.Commit(); err != nil {
+		t.Error("No error was expected for transaction commit, but: ", err)
+	}
+	if j.writes != 5 || len(j.entries) != 0 {
+		t.Error("Journal entry was expected to be removed after commit, but: ", j.writes)
+	}
+}
+
+func TestInitRecoverJournal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	j := &memJournal{entries: map[string][]byte{
+		"id1": []byte(`{"id":"tx1","time":"2020-01-01T00:00:00Z","commands":[` +
+			`{"op":"MSet","value":{"test1":1,"test2":"v"}},` +
+			`{"op":"ExpireDuration","key":"test1","value":1500000000},` +
+			`{"op":"HExpire","key":"hash","fields":["k1","k2"],"value":60},` +
+			`{"op":"HIncr","key":"hash","field":"k3","value":0.5}]}`),
+	}}
+	c := newCacheImpl(Driver(d), WithJournal(j))
+	c.shard("test1").keys["test1"] = "0"
+
+	gomock.InOrder(
+		d.EXPECT().Init().Return(nil),
+		d.EXPECT().Get("tx:tx1").Return("", driver.ErrValueNil),
+		d.EXPECT().MSet(map[string]interface{}{"test1": int64(1), "test2": "v"}).Return(nil),
+		d.EXPECT().ExpireDuration("test1", 1500*time.Millisecond).Return(nil),
+		d.EXPECT().HExpire("hash", int64(60), "k1", "k2").Return(nil),
+		d.EXPECT().HIncr("hash", "k3", 0.5).Return("1.5", nil),
+	)
+	if err := c.Init(); err != nil {
+		t.Error("No error was expected for init, but: ", err)
+	}
+	if len(j.entries) != 0 {
+		t.Error("Recovered journal entry was expected to be removed")
+	}
+	if _, ok := c.shard("test1").keys["test1"]; ok {
+		t.Error("Recovered keys should be dropped from memory")
+	}
+}
+
+func TestInitRecoverJournalImages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	j := &memJournal{entries: map[string][]byte{
+		"id1": []byte(`{"time":"2020-01-01T00:00:00Z","commands":[{"op":"Set","key":"test1","value":"new"}],` +
+			`"images":[{"key":"test1","exists":true,"value":"old","ttl":-1},{"key":"test2","exists":false,"ttl":-1}]}`),
+		"id2": []byte(`{bad`),
+	}}
+	c := newCacheImpl(Driver(d), WithJournal(j))
+
+	gomock.InOrder(
+		d.EXPECT().Init().Return(nil),
+		d.EXPECT().Del("test2").Return(nil),
+		d.EXPECT().Del("test1").Return(nil),
+		d.EXPECT().Set("test1", "old").Return(nil),
+	)
+	err := c.Init()
+	if err == nil || !strings.Contains(err.Error(), "id2") {
+		t.Error("Error of invalid journal entry was expected for init, but: ", err)
+	}
+	if _, ok := j.entries["id1"]; ok || len(j.entries) != 1 {
+		t.Error("Compensated journal entry was expected to be removed")
+	}
+}
+
+func TestInitRecoverJournalMarked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	j := &memJournal{entries: map[string][]byte{
+		"id1": []byte(`{"id":"tx1","time":"2020-01-01T00:00:00Z","commands":[{"op":"Incr","key":"counter","value":1}],` +
+			`"images":[{"key":"test1","exists":true,"value":"old","ttl":-1}]}`),
+		"id2": []byte(`{"id":"tx2","time":"2020-01-01T00:00:01Z","commands":[` +
+			`{"op":"Incr","key":"counter","value":1},{"op":"XAdd","key":"stream","field":"*","value":{"a":"1"}},` +
+			`{"op":"Set","key":"tx:tx2","value":"1"}],"done":1}`),
+	}}
+	c := newCacheImpl(Driver(d), WithJournal(j))
+
+	gomock.InOrder(
+		d.EXPECT().Init().Return(nil),
+		d.EXPECT().Get("tx:tx1").Return("1", nil),
+		d.EXPECT().Get("tx:tx2").Return("", driver.ErrValueNil),
+		d.EXPECT().XAdd("stream", "*", map[string]interface{}{"a": "1"}).Return("1-0", nil),
+		d.EXPECT().Set("tx:tx2", "1").Return(nil),
+	)
+	if err := c.Init(); err != nil {
+		t.Error("No error was expected for init, but: ", err)
+	}
+	if len(j.entries) != 0 {
+		t.Error("Recovered journal entries were expected to be removed")
+	}
+}
+
+func TestInitRecoverJournalUnmarked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	j := &memJournal{entries: map[string][]byte{
+		"id1": []byte(`{"time":"2020-01-01T00:00:00Z","commands":[{"op":"Incr","key":"counter","value":1}]}`),
+	}}
+	c := newCacheImpl(Driver(d), WithJournal(j))
+
+	d.EXPECT().Init().Return(nil)
+	if err := c.Init(); err == nil || !strings.Contains(err.Error(), ErrNoMarker.Error()) {
+		t.Error("ErrNoMarker was expected for init, but: ", err)
+	}
+	if len(j.entries) != 1 {
+		t.Error("Journal entry without transaction ID was expected to be kept")
+	}
+}
+
+func TestInitRecoverJournalImagesChanged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	j := &memJournal{entries: map[string][]byte{
+		"id1": []byte(`{"time":"2020-01-01T00:00:00Z","commands":[],"images":[` +
+			`{"key":"test1","exists":true,"value":"old","ttl":-1,"written":"new"},` +
+			`{"key":"test2","exists":true,"value":"old","ttl":-1,"written":"new"},` +
+			`{"key":"test3","exists":true,"value":"old","ttl":-1,"written":"__value_nil__"},` +
+			`{"key":"hash","exists":false,"ttl":-1,"hwritten":{"k1":"1","k2":"__value_nil__"}}]}`),
+	}}
+	c := newCacheImpl(Driver(d), WithJournal(j))
+
+	gomock.InOrder(
+		d.EXPECT().Init().Return(nil),
+		d.EXPECT().HGetAll("hash").Return(map[string]string{"k1": "1", "k2": "other"}, nil),
+		d.EXPECT().Exists("test3").Return(true, nil),
+		d.EXPECT().Get("test2").Return("new", nil),
+		d.EXPECT().Del("test2").Return(nil),
+		d.EXPECT().Set("test2", "old").Return(nil),
+		d.EXPECT().Get("test1").Return("other", nil),
+	)
+	if err := c.Init(); err != nil {
+		t.Error("No error was expected for init, but: ", err)
+	}
+	if len(j.entries) != 0 {
+		t.Error("Compensated journal entry was expected to be removed")
+	}
+}
+
+func TestCaptureImagesWritten(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	tx := c.NewTransaction()
+	tx.Set("test1", 1)
+	tx.Del("test2")
+	tx.HSet("hash", "k1", "v")
+	tx.HDel("hash", "k2")
+	d.EXPECT().Get(gomock.Any()).Return("", driver.ErrValueNil).Times(2)
+	d.EXPECT().HGetAll("hash").Return(map[string]string{}, nil)
+	images, err := tx.(*transImpl).captureImages()
+	if err != nil || len(images) != 3 {
+		t.Fatal("Before-images were expected, but: ", images, err)
+	}
+	if images[0].Written != "1" || images[1].Written != flagValueNil ||
+		images[2].HWritten["k1"] != "v" || images[2].HWritten["k2"] != flagValueNil {
+		t.Error("Values written by transaction were expected in images, but: ", images[0], images[1], images[2])
+	}
+	tx.Rollback()
+}
@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"sort"
+	"time"
+
+	"github.com/go-lego/cache/driver"
+)
+
+const (
+	lockPrefix   = "lock:"          // prefix of lock keys
+	lockLease    = 30 * time.Second // default lease of locks
+	lockInterval = 10 * time.Millisecond
+)
+
+var (
+	// lockScript set lock key to token if not set, with lease in milliseconds
+	lockScript = driver.NewScript(1, `if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then return 1 end return 0`)
+
+	// renewScript extend lease of lock key if still held by token, in milliseconds
+	renewScript = driver.NewScript(1, `if redis.call('GET', KEYS[1]) == ARGV[1] then return redis.call('PEXPIRE', KEYS[1], ARGV[2]) end return 0`)
+
+	// unlockScript delete lock key if still held by token
+	unlockScript = driver.NewScript(1, `if redis.call('GET', KEYS[1]) == ARGV[1] then return redis.call('DEL', KEYS[1]) end return 0`)
+)
+
+// Lock take locks of keys, held by the outermost transaction until it is committed or rolled back.
+// Locks held by others are waited for up to LockWait, the keys taken by the call are released on failure.
+// Leases are renewed before writes are applied, the commit fails with ErrLocked and the transaction is
+// rolled back if one expired.
+func (t *transImpl) Lock(keys ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.check(); err != nil {
+		return err
+	}
+	r := t.root()
+	if r.token == "" {
+		r.token = newID()
+	}
+	lease := r.lease()
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted) // same order for every transaction to avoid deadlocks
+	deadline := time.Now().Add(r.options.LockWait)
+	taken := []string{}
+	for _, k := range sorted {
+		if r.locks[k] {
+			continue
+		}
+		if err := r.lock(k, lease, deadline); err != nil {
+			r.unlock(taken...)
+			return err
+		}
+		if !r.locks[k] {
+			r.locks[k] = true
+			taken = append(taken, k)
+		}
+	}
+	return nil
+}
+
+// lease get lease of locks
+func (t *transImpl) lease() time.Duration {
+	if t.options.LockLease > 0 {
+		return t.options.LockLease
+	}
+	return lockLease
+}
+
+// lock take lock of key, retried until deadline. The transaction is unlocked while waiting.
+func (t *transImpl) lock(key string, lease time.Duration, deadline time.Time) error {
+	for {
+		v, err := t.c.options.Driver.Eval(lockScript, lockPrefix+key, t.token, millis(lease))
+		if err != nil {
+			return err
+		}
+		if n, _ := v.(int64); n == 1 {
+			return nil
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return ErrLocked
+		}
+		if wait > lockInterval {
+			wait = lockInterval
+		}
+		t.mu.Unlock()
+		time.Sleep(wait)
+		t.mu.Lock()
+		if err := t.check(); err != nil { // ended or expired meanwhile
+			return err
+		}
+		if t.locks[key] { // taken by another call
+			return nil
+		}
+	}
+}
+
+// renewLocks extend leases of locks held by transaction, ErrLocked if one was lost
+func (t *transImpl) renewLocks() error {
+	keys := make([]string, 0, len(t.locks))
+	for k := range t.locks {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, err := t.c.options.Driver.Eval(renewScript, lockPrefix+k, t.token, millis(t.lease()))
+		if err != nil {
+			return err
+		}
+		if n, _ := v.(int64); n != 1 {
+			return ErrLocked
+		}
+	}
+	return nil
+}
+
+// unlock release locks of keys held by transaction
+func (t *transImpl) unlock(keys ...string) {
+	for _, k := range keys {
+		t.c.options.Driver.Eval(unlockScript, lockPrefix+k, t.token)
+		delete(t.locks, k)
+	}
+}
+
+// unlockAll release every lock held by transaction
+func (t *transImpl) unlockAll() {
+	keys := make([]string, 0, len(t.locks))
+	for k := range t.locks {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	t.unlock(keys...)
+}
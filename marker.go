@@ -0,0 +1,78 @@
+package cache
+
+import "github.com/go-lego/cache/driver"
+
+const markerPrefix = "tx:" // prefix of transaction ID markers
+
+// markerKey get key of marker of transaction ID
+func markerKey(id string) string {
+	return markerPrefix + id
+}
+
+// ID get ID of transaction, savepoints share the ID of the outermost transaction
+func (t *transImpl) ID() string {
+	return t.root().id
+}
+
+// applied check if transaction ID was committed before, the marker is watched so that a concurrent
+// commit with the same ID makes the commit fail with ErrConflict
+func (t *transImpl) applied() (bool, error) {
+	key := markerKey(t.id)
+	if err := t.watch(key); err != nil {
+		return false, err
+	}
+	_, err := t.c.options.Driver.Get(key)
+	if err == driver.ErrValueNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// mark queue marker of transaction ID, applied with the writes. Applied one by one, the marker
+// is skipped if a write failed.
+func (t *transImpl) mark() {
+	if t.marked {
+		return
+	}
+	t.marked = true
+	key := markerKey(t.id)
+	ttl := t.c.options.MarkerTTL
+	if ttl <= 0 {
+		ttl = journalMarkerTTL
+	}
+	t.cmds = append(t.cmds,
+		&command{t: typeSet, args: []interface{}{key, "1"}, marker: true},
+		&command{t: typePExpire, args: []interface{}{key, millis(ttl)}, marker: true},
+	)
+}
+
+// unmark delete marker of transaction ID whose writes failed or were restored, so that
+// the transaction is not taken as applied
+func (t *transImpl) unmark() {
+	if !t.marked {
+		return
+	}
+	t.marked = false
+	t.c.options.Driver.Del(markerKey(t.id))
+}
+
+// skipApplied end transaction committed before with the same ID, counters applied again are reverted
+func (t *transImpl) skipApplied() error {
+	err := t.compensate()
+	for _, cmd := range t.cmds {
+		t.c.Invalidate(cmd.keys()...)
+	}
+	afterCommit := t.afterCommit
+	t.end()
+	t.run(afterCommit)
+	return err
+}
+
+// TransactionApplied check if transaction of ID was committed, markers are kept for MarkerTTL
+func (c *cacheImpl) TransactionApplied(id string) (bool, error) {
+	_, err := c.options.Driver.Get(markerKey(id))
+	if err == driver.ErrValueNil {
+		return false, nil
+	}
+	return err == nil, err
+}
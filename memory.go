@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const shardCount = 32 // number of memory shards, fewer if limits are lower
+
+// version number of changes of key through the cache, kept while transactions watch it
+type version struct {
+	n        uint64
+	watchers int
+}
+
+// shard part of memory holding the keys hashed to it, guarded by its own lock
+type shard struct {
+	sync.Mutex
+
+	keys     map[string]string
+	hsets    map[string]map[string]string
+	hexpires map[string]map[string]time.Time // expiration deadline of hash keys
+	delKeys  map[string]string
+	versions map[string]*version // versions of keys watched by active transactions
+
+	// generation increased by every change of memory, values read from driver are not kept
+	// if it changed meanwhile since they may be outdated
+	gen uint64
+
+	// eviction, limits are the shares of the limits of options
+	policy     EvictionPolicy
+	maxEntries int
+	maxBytes   int64
+	entries    map[string]*entry
+	recent     *list.List // entries, most recently used first
+	bytes      int64
+	pinned     map[string]int // keys used by active transactions, never evicted
+	sketch     *sketch        // frequency of keys requested, for TinyLFU
+	evictions  int64
+	rejected   int64
+}
+
+// newShards create shards of memory, limits of o are split so that the shares add up to them
+func newShards(o Options) []*shard {
+	n := shardCount
+	if o.MaxEntries > 0 && o.MaxEntries < n {
+		n = o.MaxEntries
+	}
+	if o.MaxBytes > 0 && o.MaxBytes < int64(n) {
+		n = int(o.MaxBytes)
+	}
+	shards := make([]*shard, n)
+	for i := range shards {
+		so := o
+		so.MaxEntries = o.MaxEntries / n
+		if i < o.MaxEntries%n {
+			so.MaxEntries++
+		}
+		so.MaxBytes = o.MaxBytes / int64(n)
+		if int64(i) < o.MaxBytes%int64(n) {
+			so.MaxBytes++
+		}
+		shards[i] = newShard(so)
+	}
+	return shards
+}
+
+// newShard create shard limited by limits of o
+func newShard(o Options) *shard {
+	s := &shard{
+		versions:   make(map[string]*version),
+		policy:     o.Eviction,
+		maxEntries: o.MaxEntries,
+		maxBytes:   o.MaxBytes,
+		pinned:     make(map[string]int),
+	}
+	if s.policy == TinyLFU && s.bounded() {
+		s.sketch = newSketch(s.maxEntries)
+	}
+	s.reset()
+	return s
+}
+
+// reset drop every key
+func (s *shard) reset() {
+	s.keys = make(map[string]string)
+	s.hsets = make(map[string]map[string]string)
+	s.hexpires = make(map[string]map[string]time.Time)
+	s.delKeys = make(map[string]string)
+	s.entries = make(map[string]*entry)
+	s.recent = list.New()
+	s.bytes = 0
+	s.gen++
+}
+
+// bounded check if memory is limited
+func (s *shard) bounded() bool {
+	return s.maxEntries > 0 || s.maxBytes > 0
+}
+
+// shard get shard of key
+func (c *cacheImpl) shard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+// generation get generation of shard of key
+func (c *cacheImpl) generation(key string) uint64 {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	return s.gen
+}
+
+// version get version of key, zero if not watched
+func (c *cacheImpl) version(key string) uint64 {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	if v, ok := s.versions[key]; ok {
+		return v.n
+	}
+	return 0
+}
+
+// watchVersion get version of key, counting changes until unwatchVersion
+func (c *cacheImpl) watchVersion(key string) uint64 {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	v, ok := s.versions[key]
+	if !ok {
+		v = &version{}
+		s.versions[key] = v
+	}
+	v.watchers++
+	return v.n
+}
+
+// unwatchVersion stop counting changes of key for a transaction, forgotten once no longer watched
+func (c *cacheImpl) unwatchVersion(key string) {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	if v, ok := s.versions[key]; ok {
+		if v.watchers--; v.watchers <= 0 {
+			delete(s.versions, key)
+		}
+	}
+}
+
+// fill run fn to keep values of key read from driver if memory did not change since generation gen
+func (s *shard) fill(key string, gen uint64, fn func()) {
+	s.Lock()
+	defer s.Unlock()
+	if s.gen == gen {
+		fn()
+		s.track(key)
+	}
+}
+
+// write run fn to keep values written to driver if memory did not change since generation gen,
+// key is dropped otherwise since a concurrent write may have been applied to driver first
+func (s *shard) write(key string, gen uint64, fn func()) {
+	s.Lock()
+	defer s.Unlock()
+	if s.gen == gen {
+		fn()
+		s.track(key)
+	} else {
+		s.drop(key)
+	}
+	s.gen++
+}
+
+// drop key from memory
+func (s *shard) drop(key string) {
+	delete(s.keys, key)
+	delete(s.delKeys, key)
+	delete(s.hsets, key)
+	delete(s.hexpires, key)
+	s.untrack(key)
+}
+
+func (s *shard) setHashKey(key string, hk string, val string) {
+	m, ok := s.hsets[key]
+	if !ok {
+		m = map[string]string{}
+	}
+	m[hk] = val
+	s.hsets[key] = m
+}
+
+// setHashExpire set expiration deadline of hash keys in memory, zero deadline removes it
+func (s *shard) setHashExpire(key string, hks []string, deadline time.Time) {
+	m, ok := s.hexpires[key]
+	if !ok {
+		if deadline.IsZero() {
+			return
+		}
+		m = map[string]time.Time{}
+		s.hexpires[key] = m
+	}
+	for _, hk := range hks {
+		if deadline.IsZero() {
+			delete(m, hk)
+		} else {
+			m[hk] = deadline
+		}
+	}
+	if len(m) == 0 {
+		delete(s.hexpires, key)
+	}
+}
+
+// expireHash mark expired hash keys in memory as deleted
+func (s *shard) expireHash(key string) {
+	m, ok := s.hexpires[key]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	for hk, deadline := range m {
+		if !deadline.After(now) {
+			s.setHashKey(key, hk, flagValueNil)
+			delete(m, hk)
+		}
+	}
+	if len(m) == 0 {
+		delete(s.hexpires, key)
+	}
+}
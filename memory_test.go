@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	dmock "github.com/go-lego/cache/driver/mock"
+	"github.com/golang/mock/gomock"
+)
+
+// memory merge shards of c, used to check memory
+func memory(c *cacheImpl) *shard {
+	m := newShard(Options{})
+	for _, s := range c.shards {
+		for k, v := range s.keys {
+			m.keys[k] = v
+		}
+		for k, v := range s.hsets {
+			m.hsets[k] = v
+		}
+		for k, v := range s.hexpires {
+			m.hexpires[k] = v
+		}
+		for k, v := range s.delKeys {
+			m.delKeys[k] = v
+		}
+	}
+	return m
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().Get(gomock.Any()).Return("v", nil).AnyTimes()
+	d.EXPECT().Set(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	d.EXPECT().HMSet(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	d.EXPECT().HGet(gomock.Any(), gomock.Any()).Return("1", nil).AnyTimes()
+	d.EXPECT().Incr(gomock.Any(), gomock.Any()).Return("1", nil).AnyTimes()
+	d.EXPECT().Decr(gomock.Any(), gomock.Any()).Return("0", nil).AnyTimes()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("key%d", i%8)
+				switch (g + i) % 6 {
+				case 0:
+					c.Get(key)
+				case 1:
+					c.Set(key, i)
+				case 2:
+					c.HMSet("hash"+key, map[string]interface{}{"a": i, "b": g})
+					c.HGet("hash"+key, "a")
+				case 3:
+					c.Incr("counter", 1)
+				case 4:
+					tx := c.BeginTransaction()
+					c.Set(key, g)
+					c.Get(key)
+					if i%2 == 0 {
+						tx.Commit()
+					} else {
+						tx.Rollback()
+					}
+				case 5:
+					tx := c.NewTransaction()
+					tx.Set(key, g)
+					tx.Incr("counter", 1)
+					tx.Commit()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if tx := c.getCurrentTransaction(); tx != nil {
+		t.Error("Every transaction was expected to be ended, but: ", tx.cmds)
+	}
+}
+
+func TestConcurrentWriteDuringRead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+
+	d.EXPECT().Set("test1", "new").Return(nil)
+	d.EXPECT().Get("test1").DoAndReturn(func(key string) (string, error) {
+		c.Set("test1", "new") // written while reading
+		return "old", nil
+	})
+	if v, _ := c.Get("test1"); v != "old" {
+		t.Error("Get value was expected to 'old', but: ", v)
+	}
+	if v := c.shard("test1").keys["test1"]; v != "new" {
+		t.Error("Outdated value read should not replace the value written, but: ", v)
+	}
+}
+
+func TestVersionsWatched(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d), MaxEntries(64))
+	versions := func() int {
+		n := 0
+		for _, s := range c.shards {
+			n += len(s.versions)
+		}
+		return n
+	}
+
+	d.EXPECT().Set(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	tx := c.NewTransaction()
+	tx.Watch("watched")
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("key%d", i), i)
+	}
+	if n := versions(); n != 1 {
+		t.Error("Versions were expected to be kept for watched keys only, but: ", n)
+	}
+	c.Set("watched", "changed")
+	if err := tx.Commit(); err != ErrConflict {
+		t.Error("ErrConflict was expected for change of watched key, but: ", err)
+	}
+	if n := versions(); n != 0 {
+		t.Error("Versions were expected to be dropped once unwatched, but: ", n)
+	}
+}
@@ -1,10 +1,63 @@
 package cache
 
-import "github.com/go-lego/cache/driver"
+import (
+	"context"
+	"time"
+
+	"github.com/go-lego/cache/driver"
+)
+
+// CommitPolicy policy of transaction commit when a command fails
+type CommitPolicy int
+
+const (
+	// StopOnError stop applying commands at the first failure
+	StopOnError CommitPolicy = iota
+
+	// ContinueOnError apply remaining commands after a failure
+	ContinueOnError
+
+	// CompensateOnError stop at the first failure and restore keys changed by the applied
+	// commands to their values and expirations captured before commit
+	CompensateOnError
+)
 
 // Options for cache
 type Options struct {
-	Driver driver.Driver
+	Driver        driver.Driver
+	OnCommitError CommitPolicy // policy of commit replaying commands one by one, batch commit always applies all unless compensated
+
+	// DeferredCounters queue counters of transactions until commit, values returned are
+	// computed from the last known values instead of the driver
+	DeferredCounters bool
+
+	// Coalesce drop writes superseded by later ones and merge sets of keys and hash keys
+	// before commit, a failure is then reported for the merged command
+	Coalesce bool
+
+	// Journal journal of commits, recovered by Cache.Init. Journaled commits write a marker of
+	// the transaction ID, kept for MarkerTTL or a day if zero, so that commits finished before
+	// a crash are not applied again.
+	Journal Journal
+
+	// MarkerTTL retention of markers of committed transaction IDs, markers are not written if zero.
+	// Markers are written atomically with the writes if the driver supports batch, they are
+	// deleted if a write fails or the transaction is restored by a Coordinator.
+	MarkerTTL time.Duration
+
+	// OnLeak called once for each transaction still active after LeakThreshold, with the stack
+	// trace of where it was started. Transactions are checked when the cache is used, at most
+	// once per half of LeakThreshold.
+	OnLeak        func(tx Transaction, stack []byte)
+	LeakThreshold time.Duration
+
+	// MaxEntries and MaxBytes limit keys kept in memory and their estimated size, split evenly
+	// among shards of memory so that the total never exceeds them, fewer shards are used for
+	// limits below the number of shards. Zero means no limit. Keys used by active transactions
+	// are not evicted.
+	MaxEntries int
+	MaxBytes   int64
+	Eviction   EvictionPolicy // policy of keys evicted when memory is full
 }
 
 // Option func
@@ -26,3 +79,151 @@ func Driver(d driver.Driver) Option {
 		opts.Driver = d
 	}
 }
+
+// OnCommitError option
+func OnCommitError(p CommitPolicy) Option {
+	return func(opts *Options) {
+		opts.OnCommitError = p
+	}
+}
+
+// DeferredCounters option
+func DeferredCounters() Option {
+	return func(opts *Options) {
+		opts.DeferredCounters = true
+	}
+}
+
+// Coalesce option
+func Coalesce() Option {
+	return func(opts *Options) {
+		opts.Coalesce = true
+	}
+}
+
+// TransactionMarkers option
+func TransactionMarkers(ttl time.Duration) Option {
+	return func(opts *Options) {
+		opts.MarkerTTL = ttl
+	}
+}
+
+// WithJournal option
+func WithJournal(j Journal) Option {
+	return func(opts *Options) {
+		opts.Journal = j
+	}
+}
+
+// LeakWarning option
+func LeakWarning(threshold time.Duration, fn func(tx Transaction, stack []byte)) Option {
+	return func(opts *Options) {
+		opts.LeakThreshold = threshold
+		opts.OnLeak = fn
+	}
+}
+
+// MaxEntries option
+func MaxEntries(n int) Option {
+	return func(opts *Options) {
+		opts.MaxEntries = n
+	}
+}
+
+// MaxBytes option
+func MaxBytes(n int64) Option {
+	return func(opts *Options) {
+		opts.MaxBytes = n
+	}
+}
+
+// Eviction option
+func Eviction(p EvictionPolicy) Option {
+	return func(opts *Options) {
+		opts.Eviction = p
+	}
+}
+
+// TransOptions options of transaction
+type TransOptions struct {
+	// Deadline after which the transaction is rolled back, zero for none
+	Deadline time.Time
+
+	// Context the transaction is rolled back when it is done
+	Context context.Context
+
+	// ID of transaction, a commit retried with the same ID does nothing if the first one was applied
+	ID string
+
+	// Isolation of reads, savepoints use the isolation of the outermost transaction
+	Isolation IsolationLevel
+
+	// LockWait time Lock waits for locks held by others, zero fails fast
+	LockWait time.Duration
+
+	// LockLease expiration of locks taken by Lock, released earlier on commit or rollback and renewed
+	// before writes are applied, 30s if zero
+	LockLease time.Duration
+}
+
+// TransOption func
+type TransOption func(*TransOptions)
+
+// newTransOptions create new transaction options
+func newTransOptions(opts ...TransOption) TransOptions {
+	opt := TransOptions{}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
+// WithID option
+func WithID(id string) TransOption {
+	return func(opts *TransOptions) {
+		opts.ID = id
+	}
+}
+
+// Timeout option, transaction is rolled back after d
+func Timeout(d time.Duration) TransOption {
+	return func(opts *TransOptions) {
+		opts.Deadline = time.Now().Add(d)
+	}
+}
+
+// Deadline option, transaction is rolled back at tm
+func Deadline(tm time.Time) TransOption {
+	return func(opts *TransOptions) {
+		opts.Deadline = tm
+	}
+}
+
+// Isolation option
+func Isolation(l IsolationLevel) TransOption {
+	return func(opts *TransOptions) {
+		opts.Isolation = l
+	}
+}
+
+// LockWait option
+func LockWait(d time.Duration) TransOption {
+	return func(opts *TransOptions) {
+		opts.LockWait = d
+	}
+}
+
+// LockLease option
+func LockLease(d time.Duration) TransOption {
+	return func(opts *TransOptions) {
+		opts.LockLease = d
+	}
+}
+
+// WithContext option, transaction is rolled back when ctx is done
+func WithContext(ctx context.Context) TransOption {
+	return func(opts *TransOptions) {
+		opts.Context = ctx
+	}
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+)
+
+// SQLTx SQL transaction bound to a cache transaction, cache commands are queued until
+// the SQL transaction commits
+type SQLTx struct {
+	*sql.Tx
+	Cache Transaction
+}
+
+// BindSQL bind cache transaction tx to SQL transaction stx
+func BindSQL(stx *sql.Tx, tx Transaction) *SQLTx {
+	return &SQLTx{Tx: stx, Cache: tx}
+}
+
+// Commit the SQL transaction then the cache transaction, the cache transaction is rolled back
+// if the SQL commit fails. The SQL transaction stays committed if the cache commit fails.
+func (t *SQLTx) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		t.Cache.Rollback()
+		return err
+	}
+	err := t.Cache.Commit()
+	if t.Cache.Active() { // commit aborted by hook
+		t.Cache.Rollback()
+	}
+	return err
+}
+
+// Rollback the SQL transaction and the cache transaction, counters applied immediately are compensated.
+// The error of the SQL rollback is returned first, then the one of compensating counters.
+func (t *SQLTx) Rollback() error {
+	err := t.Tx.Rollback()
+	if e := t.Cache.Rollback(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// RunInSQLTransaction run fn in a SQL transaction begun on db bound to a transaction of c started by
+// NewTransaction, both are committed if fn succeeds and rolled back otherwise. Cache commands of fn
+// are run through tx.Cache.
+func RunInSQLTransaction(ctx context.Context, db *sql.DB, opts *sql.TxOptions, c Cache, fn func(tx *SQLTx) error) error {
+	stx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	tx := BindSQL(stx, c.NewTransaction(WithContext(ctx)))
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"testing"
+
+	dmock "github.com/go-lego/cache/driver/mock"
+	"github.com/golang/mock/gomock"
+)
+
+// sqlDriver SQL driver recording transaction outcomes
+type sqlDriver struct {
+	commits   int
+	rollbacks int
+	commitErr error
+}
+
+func (d *sqlDriver) Open(name string) (sqldriver.Conn, error) {
+	return &sqlConn{d: d}, nil
+}
+
+type sqlConn struct {
+	d *sqlDriver
+}
+
+func (c *sqlConn) Prepare(query string) (sqldriver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *sqlConn) Close() error {
+	return nil
+}
+
+func (c *sqlConn) Begin() (sqldriver.Tx, error) {
+	return c, nil
+}
+
+func (c *sqlConn) Commit() error {
+	c.d.commits++
+	return c.d.commitErr
+}
+
+func (c *sqlConn) Rollback() error {
+	c.d.rollbacks++
+	return nil
+}
+
+var testSQLDriver = &sqlDriver{}
+
+func init() {
+	sql.Register("cachetest", testSQLDriver)
+}
+
+func TestRunInSQLTransaction(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+	db, _ := sql.Open("cachetest", "")
+	defer db.Close()
+	*testSQLDriver = sqlDriver{}
+
+	err := RunInSQLTransaction(context.Background(), db, nil, c, func(tx *SQLTx) error {
+		tx.Cache.Set("test1", "v1")
+		tx.Cache.HSet("hash", "k1", "v")
+		if testSQLDriver.commits != 0 {
+			t.Error("SQL transaction should not be committed while fn runs")
+		}
+		d.EXPECT().Set("test1", "v1").Return(nil)
+		d.EXPECT().HSet("hash", "k1", "v").Return(nil)
+		return nil
+	})
+	if err != nil || testSQLDriver.commits != 1 {
+		t.Error("No error was expected for commit, but: ", err)
+	}
+
+	// fn fails
+	err = RunInSQLTransaction(context.Background(), db, nil, c, func(tx *SQLTx) error {
+		d.EXPECT().Incr("counter", 1).Return("1", nil)
+		tx.Cache.Incr("counter", 1)
+		tx.Cache.Set("test1", "v2")
+		d.EXPECT().Decr("counter", 1).Return("0", nil)
+		return errors.New("test")
+	})
+	if err == nil || testSQLDriver.rollbacks != 1 || c.shard("test1").keys["test1"] != "v1" {
+		t.Error("Both transactions were expected to be rolled back, but: ", err)
+	}
+
+	// SQL commit fails
+	testSQLDriver.commitErr = errors.New("sql")
+	err = RunInSQLTransaction(context.Background(), db, nil, c, func(tx *SQLTx) error {
+		tx.Cache.Set("test1", "v3")
+		return nil
+	})
+	testSQLDriver.commitErr = nil
+	if err == nil || err.Error() != "sql" || c.shard("test1").keys["test1"] != "v1" || c.getCurrentTransaction() != nil {
+		t.Error("Cache transaction was expected to be rolled back on SQL commit failure, but: ", err)
+	}
+}
+
+func TestSQLRollbackCompensateError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	d := dmock.NewMockDriver(ctrl)
+	c := newCacheImpl(Driver(d))
+	db, _ := sql.Open("cachetest", "")
+	defer db.Close()
+
+	stx, _ := db.Begin()
+	tx := BindSQL(stx, c.NewTransaction())
+	d.EXPECT().Incr("counter", 1).Return("1", nil)
+	tx.Cache.Incr("counter", 1)
+	d.EXPECT().Decr("counter", 1).Return("", errors.New("down"))
+	err := tx.Rollback()
+	if ce, ok := err.(*CommandError); !ok || ce.Op != "Incr" || ce.Key != "counter" {
+		t.Error("CommandError of counter was expected for rollback, but: ", err)
+	}
+	if tx.Cache.Active() {
+		t.Error("Cache transaction was expected to be rolled back")
+	}
+}
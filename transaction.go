@@ -1,12 +1,68 @@
 package cache
 
-// Transaction cache transaction interface
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/go-lego/cache/driver"
+)
+
+// Transaction cache transaction interface, commands run by the transaction are
+// applied on commit, except counters which are applied immediately unless
+// DeferredCounters option is set. Commands of a transaction committed or rolled back
+// fail with ErrTransactionClosed.
 type Transaction interface {
-	// Commit the transaction
+	Commands
+
+	// Commit the transaction, with MarkerTTL option a marker of the ID is written with the writes and
+	// commit of a transaction with the ID of an applied one does nothing.
+	// Fails without applying anything if BeforeCommit of
+	// the driver or an OnCommit callback fails, the transaction stays active then.
+	// Fails with ErrTransactionExpired if the transaction expired, it is rolled back once it expires
+	// and its commands fail with ErrTransactionExpired.
+	// Commit of a transaction already committed or rolled back does nothing.
 	Commit() error
 
-	// Rollback the transaction
+	// Rollback the transaction, fails without rolling back if BeforeRollback of the driver fails.
+	// Counters failed to be compensated are reported by CommandError, the transaction is rolled back anyway.
+	// Rollback of a transaction already committed or rolled back does nothing.
 	Rollback() error
+
+	// Err get error of BeforeCreate of the driver, commit and commands of the transaction return it
+	Err() error
+
+	// OnCommit register fn called before the transaction is committed, an error aborts the commit.
+	// Writes, Commit and Rollback of the transaction fail with ErrTransactionCommitting meanwhile.
+	OnCommit(fn func() error)
+
+	// AfterCommit register fn called after every command of the transaction is applied
+	AfterCommit(fn func())
+
+	// OnRollback register fn called after the transaction is rolled back or fails with ErrConflict
+	OnRollback(fn func())
+
+	// Watch keys, commit fails with ErrConflict if any of them changed after watching.
+	// Keys read with RepeatableRead isolation are watched too.
+	// Counters changed inside the transaction are applied immediately, so they change
+	// watched keys too, unless DeferredCounters option is set.
+	Watch(keys ...string) error
+
+	// Active check if the transaction is neither committed nor rolled back
+	Active() bool
+
+	// Age get time elapsed since the transaction started
+	Age() time.Duration
+
+	// Pending get write commands queued for commit, counters applied immediately are not included
+	Pending() []Command
+
+	// ID get ID of the transaction, generated unless set by WithID option
+	ID() string
+
+	// Lock take distributed locks of keys until the transaction is committed or rolled back,
+	// waits up to LockWait option for locks held by others, then fails with ErrLocked
+	Lock(keys ...string) error
 }
 
 // TransSupport interface to support transaction
@@ -31,123 +87,1021 @@ type TransSupport interface {
 }
 
 const (
-	typeSet    = 1
-	typeDel    = 2
-	typeExpire = 3
-	typeIncr   = 4
-	typeDecr   = 5
-	typeMSet   = 6
-	typeHSet   = 7
-	typeHMSet  = 8
-	typeHDel   = 9
-	typeHIncr  = 10
-	typeHDecr  = 11
+	typeSet            = 1
+	typeDel            = 2
+	typeExpire         = 3
+	typeIncr           = 4
+	typeDecr           = 5
+	typeMSet           = 6
+	typeHSet           = 7
+	typeHMSet          = 8
+	typeHDel           = 9
+	typeHIncr          = 10
+	typeHDecr          = 11
+	typeXAdd           = 12
+	typePublish        = 13
+	typePExpire        = 14
+	typeExpireDuration = 15
+	typeExpireAt       = 16
+	typeHExpire        = 17
+	typeHPExpire       = 18
+	typeHPersist       = 19
 )
 
+// commandOps operation names of command types
+var commandOps = map[int]string{
+	typeSet:            "Set",
+	typeDel:            "Del",
+	typeExpire:         "Expire",
+	typeIncr:           "Incr",
+	typeDecr:           "Decr",
+	typeMSet:           "MSet",
+	typeHSet:           "HSet",
+	typeHMSet:          "HMSet",
+	typeHDel:           "HDel",
+	typeHIncr:          "HIncr",
+	typeHDecr:          "HDecr",
+	typeXAdd:           "XAdd",
+	typePublish:        "Publish",
+	typePExpire:        "PExpire",
+	typeExpireDuration: "ExpireDuration",
+	typeExpireAt:       "ExpireAt",
+	typeHExpire:        "HExpire",
+	typeHPExpire:       "HPExpire",
+	typeHPersist:       "HPersist",
+}
+
 type command struct {
-	t    int
-	args []interface{}
+	t        int
+	args     []interface{}
+	deferred bool // counter applied on commit
+	marker   bool // marker of transaction ID, see mark
+}
+
+// isWrite check if command is applied on commit, counters are applied immediately unless deferred
+func (cmd *command) isWrite() bool {
+	switch cmd.t {
+	case typeIncr, typeDecr, typeHIncr, typeHDecr:
+		return cmd.deferred
+	}
+	return true
+}
+
+// keys get keys of memory changed by command
+func (cmd *command) keys() []string {
+	switch cmd.t {
+	case typeXAdd, typePublish:
+		return nil
+	case typeMSet:
+		kvs := cmd.args[0].(map[string]interface{})
+		keys := make([]string, 0, len(kvs))
+		for k := range kvs {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	return []string{cmd.args[0].(string)}
+}
+
+// newCommandError create error of failed command
+func newCommandError(cmd *command, err error) *CommandError {
+	ce := &CommandError{
+		Op:   commandOps[cmd.t],
+		Args: cmd.args,
+		Err:  err,
+		cmd:  cmd,
+	}
+	if key, ok := cmd.args[0].(string); ok {
+		ce.Key = key
+		ce.Args = cmd.args[1:]
+	}
+	return ce
 }
 
 type transImpl struct {
-	active bool
+	mu      *sync.Mutex // held while the transaction is used, shared with savepoints
+	active  bool
+	id      string
+	options TransOptions
+	started time.Time
+	stack   []byte // stack trace of creation, only kept for leak warning
 
-	c *cacheImpl
+	c      *cacheImpl
+	parent *transImpl // outer transaction if this is a savepoint
 
 	cmds []*command
+	err  error // error of BeforeCreate
+
+	onCommit    []func() error
+	afterCommit []func()
+	onRollback  []func()
+
+	session driver.WatchSession // watch session if driver supports watch
+	watched map[string]uint64   // versions of watched keys
+
+	token  string          // token of locks
+	locks  map[string]bool // keys locked
+	marked bool            // marker of ID queued
+
+	timedOut   bool   // rolled back because it expired
+	committing bool   // OnCommit callbacks running with the lock released, see runOnCommit
+	disarm     func() // stop watching expiration, see arm
+
+	// memory writes of transaction, merged into memory on commit and discarded on rollback
+	keys     map[string]string
+	hsets    map[string]map[string]string
+	hexpires map[string]map[string]time.Time // expiration deadline of hash keys, zero if removed
+	delKeys  map[string]bool                 // keys deleted, values in keys and hsets are set after deletion
+
+	// values first read from driver with RepeatableRead, flagValueNil if not exist
+	pins  map[string]string
+	hpins map[string]map[string]string
+	hfull map[string]bool // hashes pinned by HGetAll
+
+	pinned map[string]bool // keys of memory not evicted while active, see pinMemory
 }
 
-func newTransImpl(c *cacheImpl) *transImpl {
-	ts, ok := c.options.Driver.(TransSupport)
-	if ok {
-		ts.BeforeCreate()
-	}
+func newTransImpl(c *cacheImpl, opts ...TransOption) *transImpl {
 	tx := &transImpl{
-		active: true,
-		c:      c,
-		cmds:   []*command{},
+		mu:      &sync.Mutex{},
+		active:  true,
+		options: newTransOptions(opts...),
+		started: time.Now(),
+		c:       c,
+		cmds:    []*command{},
+		watched: make(map[string]uint64),
+		locks:   make(map[string]bool),
+	}
+	if tx.id = tx.options.ID; tx.id == "" {
+		tx.id = newID()
+	}
+	tx.resetMemory()
+	if c.options.OnLeak != nil {
+		tx.stack = debug.Stack()
+		c.mu.Lock()
+		c.open[tx] = true
+		c.mu.Unlock()
 	}
 
-	if ok {
-		ts.AfterCreate()
+	if ts, ok := c.options.Driver.(TransSupport); ok {
+		if tx.err = ts.BeforeCreate(); tx.err == nil {
+			ts.AfterCreate()
+		}
 	}
+	tx.arm()
+	return tx
+}
 
+// newSavepoint create transaction nested in parent, it is applied to the parent on commit
+func newSavepoint(parent *transImpl, opts ...TransOption) *transImpl {
+	tx := &transImpl{
+		mu:      parent.mu,
+		active:  true,
+		options: newTransOptions(opts...),
+		started: time.Now(),
+		c:       parent.c,
+		parent:  parent,
+		cmds:    []*command{},
+		watched: make(map[string]uint64),
+	}
+	tx.resetMemory()
+	tx.arm()
 	return tx
 }
 
-// Commit transaction
+// inside check if transaction is nested in t
+func (t *transImpl) inside(o *transImpl) bool {
+	for p := t.parent; p != nil; p = p.parent {
+		if p == o {
+			return true
+		}
+	}
+	return false
+}
+
+// rollbackInner roll back active transactions nested in t, the current transaction is only
+// checked once known to be nested since others are guarded by their own lock
+func (t *transImpl) rollbackInner() {
+	for tx := t.c.current(); tx != nil && tx != t && tx.inside(t) && tx.active; tx = t.c.current() {
+		tx.rollback()
+	}
+}
+
+// release lock of transaction, nil is ignored
+func (t *transImpl) release() {
+	if t != nil {
+		t.mu.Unlock()
+	}
+}
+
+// unlocked run fn with the lock of transaction released, for driver round-trips. Goroutines
+// sharing the transaction may use it meanwhile, even end it. fn is only run if t is nil.
+func (t *transImpl) unlocked(fn func()) {
+	if t == nil {
+		fn()
+		return
+	}
+	t.mu.Unlock()
+	defer t.mu.Lock()
+	fn()
+}
+
+// run callbacks with the lock released, so that they can use the transaction
+func (t *transImpl) run(fns []func()) {
+	t.mu.Unlock()
+	defer t.mu.Lock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// expired check if deadline of transaction passed or its context is done
+func (t *transImpl) expired() bool {
+	if d := t.options.Deadline; !d.IsZero() && !time.Now().Before(d) {
+		return true
+	}
+	return t.options.Context != nil && t.options.Context.Err() != nil
+}
+
+// expiredScope get outermost expired transaction among t and its outer transactions, nil if none
+func (t *transImpl) expiredScope() *transImpl {
+	var e *transImpl
+	for p := t; p != nil; p = p.parent {
+		if p.expired() {
+			e = p
+		}
+	}
+	return e
+}
+
+// arm roll back transaction once its deadline passes or its context is done, so that
+// its watch session and locks are released even if it is not used anymore
+func (t *transImpl) arm() {
+	d, ctx := t.options.Deadline, t.options.Context
+	if d.IsZero() && ctx == nil {
+		return
+	}
+	var timer *time.Timer
+	if !d.IsZero() {
+		timer = time.AfterFunc(time.Until(d), t.timeout)
+	}
+	done := make(chan struct{})
+	if ctx != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				t.timeout()
+			case <-done:
+			}
+		}()
+	}
+	t.disarm = func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		close(done)
+	}
+}
+
+// timeout roll back transaction if still active and expired
+func (t *transImpl) timeout() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active && t.expired() {
+		t.expire()
+	}
+}
+
+// expire roll back expired transaction, its commands fail with ErrTransactionExpired then
+func (t *transImpl) expire() {
+	t.timedOut = true
+	t.rollback()
+}
+
+// Active check if the transaction is neither committed nor rolled back
+func (t *transImpl) Active() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// Age get time elapsed since the transaction started
+func (t *transImpl) Age() time.Duration {
+	return time.Since(t.started)
+}
+
+// Err get error of BeforeCreate of the driver
+func (t *transImpl) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// check get error of commands run by transaction, nil if it can run them, nil is ignored.
+// Commands of a transaction whose BeforeCreate failed return its error.
+func (t *transImpl) check() error {
+	if t == nil {
+		return nil
+	}
+	for p := t; p != nil; p = p.parent {
+		if p.timedOut {
+			return ErrTransactionExpired
+		}
+	}
+	if !t.active {
+		return ErrTransactionClosed
+	}
+	if e := t.expiredScope(); e != nil { // not rolled back by arm yet
+		e.expire()
+		return ErrTransactionExpired
+	}
+	return t.root().err
+}
+
+// checkWrite check transaction for commands changing it, they are rejected while OnCommit
+// callbacks run
+func (t *transImpl) checkWrite() error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if t != nil && t.root().committing {
+		return ErrTransactionCommitting
+	}
+	return nil
+}
+
+// usable check transaction with its lock taken, for commands not holding it
+func (t *transImpl) usable() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.check()
+}
+
+// OnCommit register fn called before the transaction is committed
+func (t *transImpl) OnCommit(fn func() error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onCommit = append(t.onCommit, fn)
+}
+
+// AfterCommit register fn called after every command of the transaction is applied
+func (t *transImpl) AfterCommit(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.afterCommit = append(t.afterCommit, fn)
+}
+
+// OnRollback register fn called after the transaction is rolled back
+func (t *transImpl) OnRollback(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onRollback = append(t.onRollback, fn)
+}
+
+// Watch keys, they are dropped from memory so that reads come from driver.
+// Keys watched by a savepoint are watched by the outermost transaction.
+func (t *transImpl) Watch(keys ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.watch(keys...)
+}
+
+func (t *transImpl) watch(keys ...string) error {
+	if t.parent != nil {
+		return t.parent.watch(keys...)
+	}
+	if ws, ok := t.c.options.Driver.(driver.WatchSupport); ok {
+		if t.session == nil {
+			s, err := ws.Watch(keys...)
+			if err != nil {
+				return err
+			}
+			t.session = s
+		} else if err := t.session.Watch(keys...); err != nil {
+			return err
+		}
+	}
+	for _, k := range keys {
+		if _, ok := t.watched[k]; !ok {
+			t.watched[k] = t.c.watchVersion(k)
+		}
+	}
+	t.c.Invalidate(keys...)
+	return nil
+}
+
+// changed check if any watched key changed through the cache
+func (t *transImpl) changed() bool {
+	for k, v := range t.watched {
+		if t.c.version(k) != v {
+			return true
+		}
+	}
+	return false
+}
+
+// Commit transaction, the writes are applied atomically if the driver supports batch.
+// Failed commands are reported by CommitError and dropped from memory, with CompensateOnError
+// the applied commands are restored from before-images and the transaction is rolled back.
+// If watched keys changed nothing is applied and ErrConflict is returned.
+// Commit of a savepoint only merges its commands, memory and callbacks into the outer transaction.
+// Active inner transactions are rolled back first.
+// If BeforeCreate of the driver failed, the transaction is rolled back and the error is returned.
 func (t *transImpl) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.commit()
+}
+
+func (t *transImpl) commit() error {
+	switch err := t.check(); err {
+	case ErrTransactionExpired:
+		return err
+	case ErrTransactionClosed:
+		return nil
+	}
+	if t.root().committing {
+		return ErrTransactionCommitting
+	}
+	t.rollbackInner()
+	if t.err != nil {
+		t.rollback()
+		return t.err
+	}
+	if p := t.parent; p != nil {
+		t.merge(nil)
+		p.cmds = append(p.cmds, t.cmds...)
+		p.onCommit = append(p.onCommit, t.onCommit...)
+		p.afterCommit = append(p.afterCommit, t.afterCommit...)
+		p.onRollback = append(p.onRollback, t.onRollback...)
+		t.end()
+		return nil
+	}
+	if t.c.options.MarkerTTL > 0 {
+		if applied, err := t.applied(); err != nil {
+			return err
+		} else if applied {
+			return t.skipApplied()
+		}
+	}
+	images, err := t.prepare(t.c.options.OnCommitError == CompensateOnError)
+	if err != nil {
+		return err
+	}
+	return t.commitPrepared(images)
+}
+
+// prepare run BeforeCommit of the driver and OnCommit callbacks, before-images are captured if compensate is set
+func (t *transImpl) prepare(compensate bool) ([]*image, error) {
+	if ts, ok := t.c.options.Driver.(TransSupport); ok {
+		if err := ts.BeforeCommit(); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.runOnCommit(); err != nil {
+		return nil, err
+	}
+	if err := t.check(); err != nil { // expired while callbacks ran
+		return nil, err
+	}
+	if t.c.options.MarkerTTL > 0 || t.c.options.Journal != nil {
+		t.mark()
+	}
+	if !compensate {
+		return nil, nil
+	}
+	return t.captureImages()
+}
+
+// runOnCommit run OnCommit callbacks with the lock released, stops at the first error. The
+// transaction is committing meanwhile, writes, Commit and Rollback from other goroutines or
+// the callbacks fail with ErrTransactionCommitting.
+func (t *transImpl) runOnCommit() error {
+	fns, n := t.onCommit, len(t.cmds)
+	t.committing = true
+	t.mu.Unlock()
+	err := runAll(fns)
+	t.mu.Lock()
+	t.committing = false
+	if err != nil {
+		return err
+	}
+	if t.active && len(t.cmds) != n { // changed while unlocked
+		return ErrTransactionCommitting
+	}
+	return nil
+}
+
+// runAll call fns in order, stops at the first error
+func runAll(fns []func() error) error {
+	for _, fn := range fns {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitPrepared apply writes of prepared transaction and end it, applied commands are restored from images if a command fails
+func (t *transImpl) commitPrepared(images []*image) error {
+	applied, undone, err := t.applyPrepared(images)
+	if applied {
+		t.finish(err == nil, undone)
+	} else if err == ErrLocked { // lease expired, isolation is lost
+		t.rollback()
+	}
+	return err
+}
+
+// applyPrepared apply writes of prepared transaction without ending it, applied commands are restored
+// from images if a command fails. undone is set if nothing of the transaction is kept, applied is
+// false if nothing was attempted.
+func (t *transImpl) applyPrepared(images []*image) (applied bool, undone bool, err error) {
 	d := t.c.options.Driver
+	if t.c.options.Coalesce {
+		t.coalesce()
+	}
 	ts, ok := d.(TransSupport)
-	if ok {
-		ts.BeforeCommit()
+	if err := t.renewLocks(); err != nil {
+		return false, false, err
 	}
-	if t.cmds != nil {
-
+	jid, je, err := t.writeJournal(images)
+	if err != nil {
+		return false, false, err
+	}
+	gens := t.generations()
+	var failed []*CommandError
+	conflict := false
+	if t.session != nil {
+		failed, err = t.commitBatch(t.session)
+		conflict = err == driver.ErrConflict // even without writes
+	} else if t.changed() || t.readsChanged() {
+		conflict = true
+	} else if bs, o := d.(driver.BatchSupport); o {
+		failed, _ = t.commitBatch(bs)
+	} else {
+		failed = t.commitSequential(d, func(n int) { t.c.advanceJournal(jid, je, n) })
+	}
+	var ce *CommitError
+	if len(failed) > 0 && !conflict {
+		ce = &CommitError{Failed: failed}
+		t.unmark()
+		if images != nil {
+			ce.CompensateErrors = t.restoreImages(images, failed)
+			ce.Compensated = len(ce.CompensateErrors) == 0
+		}
+	}
+	undone = conflict || (ce != nil && images != nil) // nothing of transaction is kept
+	if undone {
+		if err := t.compensate(); err != nil && ce != nil {
+			ce.CompensateErrors = append(ce.CompensateErrors, err)
+			ce.Compensated = false
+		}
+		for k := range t.watched {
+			t.c.Invalidate(k)
+		}
 		for _, cmd := range t.cmds {
-			var err error
-			switch cmd.t {
-			case typeSet:
-				err = d.Set(cmd.args[0].(string), cmd.args[1])
-			case typeDel:
-				err = d.Del(cmd.args[0].(string))
-			case typeExpire:
-				err = d.Expire(cmd.args[0].(string), cmd.args[1].(int64))
-			case typeMSet:
-				err = d.MSet(cmd.args[0].(map[string]interface{}))
-			case typeHSet:
-				err = d.HSet(cmd.args[0].(string), cmd.args[1].(string), cmd.args[2])
-			case typeHMSet:
-				err = d.HMSet(cmd.args[0].(string), cmd.args[1].(map[string]interface{}))
-			case typeHDel:
-				err = d.HDel(cmd.args[0].(string), cmd.args[1].(string))
+			t.c.Invalidate(cmd.keys()...)
+		}
+	} else {
+		t.merge(gens)
+		for _, cmd := range t.cmds {
+			if cmd.isWrite() {
+				t.c.touch(cmd.keys()...)
 			}
-			if err != nil {
-				// TODO
+		}
+		for _, ce := range failed { // memory no longer matches the driver
+			t.c.Invalidate(ce.cmd.keys()...)
+		}
+		for _, cmd := range t.cmds { // values computed locally may be outdated
+			if cmd.deferred {
+				t.c.Invalidate(cmd.keys()...)
 			}
 		}
 	}
+	if jid != "" {
+		t.c.options.Journal.Remove(jid)
+	}
 	if ok {
 		ts.AfterCommit()
 	}
+	if conflict {
+		return true, undone, ErrConflict
+	}
+	if ce != nil {
+		return true, undone, ce
+	}
+	return true, false, nil
+}
+
+// finish end transaction whose writes were applied, then run AfterCommit callbacks if committed
+// and OnRollback ones if undone
+func (t *transImpl) finish(committed bool, undone bool) {
+	afterCommit, onRollback := t.afterCommit, t.onRollback
+	t.end()
+	if undone {
+		t.run(onRollback)
+	}
+	if committed {
+		t.run(afterCommit)
+	}
+}
+
+// end deactivate transaction and release watch session and locks, the outer transaction becomes current
+func (t *transImpl) end() {
+	if t.session != nil {
+		t.session.Close()
+		t.session = nil
+	}
+	if t.disarm != nil {
+		t.disarm()
+		t.disarm = nil
+	}
+	t.unlockAll()
+	t.unpinMemory()
+	for k := range t.watched {
+		t.c.unwatchVersion(k)
+	}
 	t.active = false
 	t.cmds = []*command{}
-	return nil
+	t.watched = make(map[string]uint64)
+	t.onCommit = nil
+	t.afterCommit = nil
+	t.onRollback = nil
+	t.resetMemory()
+	c := t.c
+	c.mu.Lock()
+	delete(c.open, t)
+	if t.parent != nil && c.tx == t {
+		c.tx = t.parent
+	}
+	c.mu.Unlock()
 }
 
-// Rollback transaction
-func (t *transImpl) Rollback() error {
-	d := t.c.options.Driver
-	ts, ok := d.(TransSupport)
-	if ok {
-		ts.BeforeRollback()
-	}
-	if t.cmds != nil {
-		l := len(t.cmds)
-		for i := l - 1; i >= 0; i-- {
-			var err error
-			cmd := t.cmds[i]
-			switch cmd.t {
-			case typeIncr:
-				_, err = d.Decr(cmd.args[0].(string), cmd.args[1])
-			case typeDecr:
-				_, err = d.Incr(cmd.args[0].(string), cmd.args[1])
-			case typeHIncr:
-				_, err = d.HDecr(cmd.args[0].(string), cmd.args[1].(string), cmd.args[2])
-			case typeHDecr:
-				_, err = d.HIncr(cmd.args[0].(string), cmd.args[1].(string), cmd.args[2])
+// resetMemory discard memory writes of transaction
+func (t *transImpl) resetMemory() {
+	t.keys = make(map[string]string)
+	t.hsets = make(map[string]map[string]string)
+	t.hexpires = make(map[string]map[string]time.Time)
+	t.delKeys = make(map[string]bool)
+	t.pins = make(map[string]string)
+	t.hpins = make(map[string]map[string]string)
+	t.hfull = make(map[string]bool)
+}
+
+// generations get generations of memory of keys written by transaction, taken before its writes are applied
+func (t *transImpl) generations() map[string]uint64 {
+	gens := map[string]uint64{}
+	for k := range t.delKeys {
+		gens[k] = t.c.generation(k)
+	}
+	for k := range t.keys {
+		gens[k] = t.c.generation(k)
+	}
+	for k := range t.hsets {
+		gens[k] = t.c.generation(k)
+	}
+	for k := range t.hexpires {
+		gens[k] = t.c.generation(k)
+	}
+	return gens
+}
+
+// merge memory writes of transaction into memory, or into the outer transaction.
+// Keys of memory changed since generations gens are dropped instead.
+func (t *transImpl) merge(gens map[string]uint64) {
+	if p := t.parent; p != nil {
+		for k := range t.delKeys {
+			p.delKey(k)
+		}
+		for k, v := range t.keys {
+			p.setKey(k, v)
+		}
+		for k, m := range t.hsets {
+			for hk, v := range m {
+				p.setHashKey(k, hk, v)
 			}
-			if err != nil {
-				// TODO
+		}
+		for k, m := range t.hexpires {
+			for hk, deadline := range m {
+				p.setHashExpire(k, []string{hk}, deadline)
 			}
 		}
+		return
+	}
+	for k := range gens {
+		s := t.c.shard(k)
+		s.write(k, gens[k], func() {
+			if t.delKeys[k] { // deleted before the values below were set
+				delete(s.keys, k)
+				delete(s.hsets, k)
+				delete(s.hexpires, k)
+				s.delKeys[k] = ""
+			}
+			if v, ok := t.keys[k]; ok {
+				delete(s.delKeys, k)
+				s.keys[k] = v
+			}
+			if m, ok := t.hsets[k]; ok {
+				delete(s.delKeys, k)
+				for hk, v := range m {
+					s.setHashKey(k, hk, v)
+				}
+			}
+			for hk, deadline := range t.hexpires[k] {
+				s.setHashExpire(k, []string{hk}, deadline)
+			}
+		})
+	}
+}
+
+// getKey get value of key written by transaction, flagValueNil if deleted.
+// ok is false if the key was not written.
+func (t *transImpl) getKey(key string) (v string, ok bool) {
+	if v, ok = t.keys[key]; ok {
+		return v, true
+	}
+	if t.delKeys[key] {
+		return flagValueNil, true
+	}
+	if t.parent != nil {
+		return t.parent.getKey(key)
+	}
+	return "", false
+}
+
+// getHashKey get value of hash key written by transaction, flagValueNil if deleted or expired.
+// ok is false if the hash key was not written.
+func (t *transImpl) getHashKey(key string, hk string) (v string, ok bool) {
+	if deadline, o := t.hexpires[key][hk]; o && !deadline.IsZero() && !deadline.After(time.Now()) {
+		return flagValueNil, true
+	}
+	if v, ok = t.hsets[key][hk]; ok {
+		return v, true
+	}
+	if t.delKeys[key] {
+		return flagValueNil, true
+	}
+	if t.parent != nil {
+		return t.parent.getHashKey(key, hk)
+	}
+	return "", false
+}
+
+// deleted check if transaction or outer ones deleted key
+func (t *transImpl) deleted(key string) bool {
+	for tx := t; tx != nil; tx = tx.parent {
+		if tx.delKeys[key] {
+			return true
+		}
 	}
+	return false
+}
+
+// hashKeys get hash keys of key written by transaction or outer ones
+func (t *transImpl) hashKeys(key string) []string {
+	hks := []string{}
+	for tx := t; tx != nil; tx = tx.parent {
+		for hk := range tx.hsets[key] {
+			hks = append(hks, hk)
+		}
+	}
+	return hks
+}
+
+// hasHashKeys check if transaction set any hash key of key
+func (t *transImpl) hasHashKeys(key string) bool {
+	for _, hk := range t.hashKeys(key) {
+		if v, _ := t.getHashKey(key, hk); v != flagValueNil {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *transImpl) setKey(key string, v string) {
+	t.keys[key] = v
+}
+
+func (t *transImpl) delKey(key string) {
+	delete(t.keys, key)
+	delete(t.hsets, key)
+	delete(t.hexpires, key)
+	t.delKeys[key] = true
+}
+
+func (t *transImpl) setHashKey(key string, hk string, v string) {
+	m, ok := t.hsets[key]
+	if !ok {
+		m = map[string]string{}
+		t.hsets[key] = m
+	}
+	m[hk] = v
+}
+
+// setHashExpire set expiration deadline of hash keys, zero deadline removes it
+func (t *transImpl) setHashExpire(key string, hks []string, deadline time.Time) {
+	m, ok := t.hexpires[key]
+	if !ok {
+		m = map[string]time.Time{}
+		t.hexpires[key] = m
+	}
+	for _, hk := range hks {
+		m[hk] = deadline
+	}
+}
+
+// commitBatch apply writes in one batch, err is the error of the whole batch if it was not applied
+func (t *transImpl) commitBatch(bs driver.BatchSupport) (failed []*CommandError, err error) {
+	queued := []*command{}
+	err = bs.Batch(func(b driver.Driver) error {
+		for _, cmd := range t.cmds {
+			if !cmd.isWrite() {
+				continue
+			}
+			queued = append(queued, cmd)
+			if e := apply(b, cmd); e != nil && e != driver.ErrQueued {
+				return e
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if be, ok := err.(*driver.BatchError); ok {
+		for i, cmd := range queued {
+			if e, o := be.Errors[i]; o {
+				failed = append(failed, newCommandError(cmd, e))
+			}
+		}
+		return failed, nil
+	}
+	// batch discarded, none of the writes applied
+	for _, cmd := range t.cmds {
+		if cmd.isWrite() {
+			failed = append(failed, newCommandError(cmd, err))
+		}
+	}
+	return failed, err
+}
+
+// commitSequential apply writes one by one following the commit policy, done is called with
+// the number of writes run after each of them
+func (t *transImpl) commitSequential(d driver.Driver, done func(n int)) []*CommandError {
+	failed := []*CommandError{}
+	n := 0
+	for _, cmd := range t.cmds {
+		if !cmd.isWrite() {
+			continue
+		}
+		if cmd.marker && len(failed) > 0 { // not applied
+			continue
+		}
+		if len(failed) > 0 && t.c.options.OnCommitError != ContinueOnError {
+			failed = append(failed, newCommandError(cmd, ErrNotApplied))
+			continue
+		}
+		if err := apply(d, cmd); err != nil {
+			failed = append(failed, newCommandError(cmd, err))
+		}
+		n++
+		done(n)
+	}
+	return failed
+}
+
+// writer write commands of both Driver and Commands
+type writer interface {
+	Set(key string, value interface{}) error
+	Del(key string) error
+	Expire(key string, ex int64) error
+	PExpire(key string, ms int64) error
+	ExpireDuration(key string, d time.Duration) error
+	ExpireAt(key string, tm time.Time) error
+	Incr(key string, delta interface{}) (string, error)
+	Decr(key string, delta interface{}) (string, error)
+	MSet(kvs map[string]interface{}) error
+	HSet(key string, hk string, value interface{}) error
+	HMSet(key string, kvs map[string]interface{}) error
+	HDel(key string, hk string) error
+	HIncr(key string, hk string, delta interface{}) (string, error)
+	HDecr(key string, hk string, delta interface{}) (string, error)
+	HExpire(key string, ex int64, hks ...string) error
+	HPExpire(key string, ms int64, hks ...string) error
+	HPersist(key string, hks ...string) error
+	XAdd(key string, id string, values map[string]interface{}) (string, error)
+	Publish(channel string, msg interface{}) (int64, error)
+}
+
+// apply run write command against d
+func apply(d writer, cmd *command) error {
+	var err error
+	switch cmd.t {
+	case typeSet:
+		err = d.Set(cmd.args[0].(string), cmd.args[1])
+	case typeDel:
+		err = d.Del(cmd.args[0].(string))
+	case typeExpire:
+		err = d.Expire(cmd.args[0].(string), cmd.args[1].(int64))
+	case typePExpire:
+		err = d.PExpire(cmd.args[0].(string), cmd.args[1].(int64))
+	case typeExpireDuration:
+		err = d.ExpireDuration(cmd.args[0].(string), cmd.args[1].(time.Duration))
+	case typeExpireAt:
+		err = d.ExpireAt(cmd.args[0].(string), cmd.args[1].(time.Time))
+	case typeMSet:
+		err = d.MSet(cmd.args[0].(map[string]interface{}))
+	case typeHSet:
+		err = d.HSet(cmd.args[0].(string), cmd.args[1].(string), cmd.args[2])
+	case typeHMSet:
+		err = d.HMSet(cmd.args[0].(string), cmd.args[1].(map[string]interface{}))
+	case typeHDel:
+		err = d.HDel(cmd.args[0].(string), cmd.args[1].(string))
+	case typeHExpire:
+		err = d.HExpire(cmd.args[0].(string), cmd.args[1].(int64), cmd.args[2].([]string)...)
+	case typeHPExpire:
+		err = d.HPExpire(cmd.args[0].(string), cmd.args[1].(int64), cmd.args[2].([]string)...)
+	case typeHPersist:
+		err = d.HPersist(cmd.args[0].(string), cmd.args[1].([]string)...)
+	case typeXAdd:
+		_, err = d.XAdd(cmd.args[0].(string), cmd.args[1].(string), cmd.args[2].(map[string]interface{}))
+	case typePublish:
+		_, err = d.Publish(cmd.args[0].(string), cmd.args[1])
+	case typeIncr:
+		_, err = d.Incr(cmd.args[0].(string), cmd.args[1])
+	case typeDecr:
+		_, err = d.Decr(cmd.args[0].(string), cmd.args[1])
+	case typeHIncr:
+		_, err = d.HIncr(cmd.args[0].(string), cmd.args[1].(string), cmd.args[2])
+	case typeHDecr:
+		_, err = d.HDecr(cmd.args[0].(string), cmd.args[1].(string), cmd.args[2])
+	}
+	return err
+}
+
+// Rollback transaction, rollback of a savepoint discards its own commands only
+func (t *transImpl) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil
+	}
+	if t.root().committing {
+		return ErrTransactionCommitting
+	}
+	return t.rollback()
+}
+
+func (t *transImpl) rollback() error {
+	t.rollbackInner()
+	ts, ok := t.c.options.Driver.(TransSupport)
+	ok = ok && t.parent == nil && t.err == nil // hooks of savepoints and failed creation are skipped
+	if ok {
+		if err := ts.BeforeRollback(); err != nil {
+			return err
+		}
+	}
+	err := t.compensate()
 	if ok {
 		ts.AfterRollback()
 	}
-	t.active = false
-	t.cmds = []*command{}
-	return nil
+	onRollback := t.onRollback
+	t.end()
+	t.run(onRollback)
+	return err
+}
+
+// compensate revert counters applied immediately, in reverse order. Every counter is tried,
+// the first failure is returned.
+func (t *transImpl) compensate() error {
+	var first error
+	d := t.c.options.Driver
+	l := len(t.cmds)
+	for i := l - 1; i >= 0; i-- {
+		var err error
+		cmd := t.cmds[i]
+		if cmd.deferred { // never applied
+			continue
+		}
+		switch cmd.t {
+		case typeIncr:
+			_, err = d.Decr(cmd.args[0].(string), cmd.args[1])
+		case typeDecr:
+			_, err = d.Incr(cmd.args[0].(string), cmd.args[1])
+		case typeHIncr:
+			_, err = d.HDecr(cmd.args[0].(string), cmd.args[1].(string), cmd.args[2])
+		case typeHDecr:
+			_, err = d.HIncr(cmd.args[0].(string), cmd.args[1].(string), cmd.args[2])
+		}
+		if err != nil && first == nil {
+			first = newCommandError(cmd, err)
+		}
+	}
+	return first
 }
 
 func (t *transImpl) onSet(key string, value interface{}) {
@@ -171,17 +1125,40 @@ func (t *transImpl) onExpire(key string, ex int64) {
 	})
 }
 
-func (t *transImpl) onIncr(key string, delta interface{}) {
+func (t *transImpl) onPExpire(key string, ms int64) {
 	t.cmds = append(t.cmds, &command{
-		t:    typeIncr,
-		args: []interface{}{key, delta},
+		t:    typePExpire,
+		args: []interface{}{key, ms},
 	})
 }
 
-func (t *transImpl) onDecr(key string, delta interface{}) {
+func (t *transImpl) onExpireDuration(key string, d time.Duration) {
 	t.cmds = append(t.cmds, &command{
-		t:    typeDecr,
-		args: []interface{}{key, delta},
+		t:    typeExpireDuration,
+		args: []interface{}{key, d},
+	})
+}
+
+func (t *transImpl) onExpireAt(key string, tm time.Time) {
+	t.cmds = append(t.cmds, &command{
+		t:    typeExpireAt,
+		args: []interface{}{key, tm},
+	})
+}
+
+func (t *transImpl) onIncr(key string, delta interface{}, deferred bool) {
+	t.cmds = append(t.cmds, &command{
+		t:        typeIncr,
+		args:     []interface{}{key, delta},
+		deferred: deferred,
+	})
+}
+
+func (t *transImpl) onDecr(key string, delta interface{}, deferred bool) {
+	t.cmds = append(t.cmds, &command{
+		t:        typeDecr,
+		args:     []interface{}{key, delta},
+		deferred: deferred,
 	})
 }
 
@@ -213,16 +1190,429 @@ func (t *transImpl) onHDel(key string, hk string) {
 	})
 }
 
-func (t *transImpl) onHIncr(key string, hk string, delta interface{}) {
+func (t *transImpl) onHExpire(key string, ex int64, hks []string) {
+	t.cmds = append(t.cmds, &command{
+		t:    typeHExpire,
+		args: []interface{}{key, ex, hks},
+	})
+}
+
+func (t *transImpl) onHPExpire(key string, ms int64, hks []string) {
+	t.cmds = append(t.cmds, &command{
+		t:    typeHPExpire,
+		args: []interface{}{key, ms, hks},
+	})
+}
+
+func (t *transImpl) onHPersist(key string, hks []string) {
+	t.cmds = append(t.cmds, &command{
+		t:    typeHPersist,
+		args: []interface{}{key, hks},
+	})
+}
+
+func (t *transImpl) onHIncr(key string, hk string, delta interface{}, deferred bool) {
+	t.cmds = append(t.cmds, &command{
+		t:        typeHIncr,
+		args:     []interface{}{key, hk, delta},
+		deferred: deferred,
+	})
+}
+
+func (t *transImpl) onHDecr(key string, hk string, delta interface{}, deferred bool) {
+	t.cmds = append(t.cmds, &command{
+		t:        typeHDecr,
+		args:     []interface{}{key, hk, delta},
+		deferred: deferred,
+	})
+}
+
+func (t *transImpl) onXAdd(key string, id string, values map[string]interface{}) {
 	t.cmds = append(t.cmds, &command{
-		t:    typeHIncr,
-		args: []interface{}{key, hk, delta},
+		t:    typeXAdd,
+		args: []interface{}{key, id, values},
 	})
 }
 
-func (t *transImpl) onHDecr(key string, hk string, delta interface{}) {
+func (t *transImpl) onPublish(channel string, msg interface{}) {
 	t.cmds = append(t.cmds, &command{
-		t:    typeHDecr,
-		args: []interface{}{key, hk, delta},
+		t:    typePublish,
+		args: []interface{}{channel, msg},
 	})
 }
+
+// func for keys
+
+// Get value by key
+func (t *transImpl) Get(key string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.check(); err != nil {
+		return "", err
+	}
+	return t.c.get(t, key)
+}
+
+// Set key-value pair
+func (t *transImpl) Set(key string, value interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.set(t, key, value)
+}
+
+// MGet get multiple keys
+func (t *transImpl) MGet(keys []string) (map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	return t.c.mGet(t, keys)
+}
+
+// MSet set multiple key-value pairs
+func (t *transImpl) MSet(kvs map[string]interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.mSet(t, kvs)
+}
+
+// Del delete specified key
+func (t *transImpl) Del(key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.del(t, key)
+}
+
+// Check if the given key exists
+func (t *transImpl) Exists(key string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.check(); err != nil {
+		return false, err
+	}
+	return t.c.exists(t, key)
+}
+
+// Expire set key expiration in seconds
+func (t *transImpl) Expire(key string, ex int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.expire(t, key, ex)
+}
+
+// PExpire set key expiration in milliseconds
+func (t *transImpl) PExpire(key string, ms int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.pExpire(t, key, ms)
+}
+
+// ExpireDuration set key expiration after duration d
+func (t *transImpl) ExpireDuration(key string, d time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.expireDuration(t, key, d)
+}
+
+// ExpireAt set key expiration at time tm
+func (t *transImpl) ExpireAt(key string, tm time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.expireAt(t, key, tm)
+}
+
+// TTL get remaining time to live of key, -1 means no expiration, ErrValueNil if key not exist
+func (t *transImpl) TTL(key string) (time.Duration, error) {
+	if err := t.usable(); err != nil {
+		return 0, err
+	}
+	return t.c.TTL(key)
+}
+
+// Incr increment key
+func (t *transImpl) Incr(key string, delta interface{}) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return "", err
+	}
+	return t.c.incr(t, key, delta)
+}
+
+// Decr Decrement key
+func (t *transImpl) Decr(key string, delta interface{}) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return "", err
+	}
+	return t.c.decr(t, key, delta)
+}
+
+// func for hashes
+
+// HGEt get hash key
+func (t *transImpl) HGet(key string, hk string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.check(); err != nil {
+		return "", err
+	}
+	return t.c.hGet(t, key, hk)
+}
+
+// HSet set hash key
+func (t *transImpl) HSet(key string, hk string, value interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.hSet(t, key, hk, value)
+}
+
+// HMGet get multiple hash keys
+func (t *transImpl) HMGet(key string, hks []string) (map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	return t.c.hMGet(t, key, hks)
+}
+
+// HMSet set multiple hash keys
+func (t *transImpl) HMSet(key string, kvs map[string]interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.hMSet(t, key, kvs)
+}
+
+// HGetAll get all hash keys
+func (t *transImpl) HGetAll(key string) (map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	return t.c.hGetAll(t, key)
+}
+
+// HDel delete hash key
+func (t *transImpl) HDel(key string, hk string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.hDel(t, key, hk)
+}
+
+// HExists check if the given hash key exists
+func (t *transImpl) HExists(key string, hk string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.check(); err != nil {
+		return false, err
+	}
+	return t.c.hExists(t, key, hk)
+}
+
+// HIncr increment value of hash key
+func (t *transImpl) HIncr(key string, hk string, delta interface{}) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return "", err
+	}
+	return t.c.hIncr(t, key, hk, delta)
+}
+
+// HDecr decrement value of hash key
+func (t *transImpl) HDecr(key string, hk string, delta interface{}) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return "", err
+	}
+	return t.c.hDecr(t, key, hk, delta)
+}
+
+// HExpire set expiration of hash keys in seconds
+func (t *transImpl) HExpire(key string, ex int64, hks ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.hExpire(t, key, ex, hks...)
+}
+
+// HPExpire set expiration of hash keys in milliseconds
+func (t *transImpl) HPExpire(key string, ms int64, hks ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.hPExpire(t, key, ms, hks...)
+}
+
+// HTTL get remaining time to live of hash keys, -1 means no expiration, missing hash keys are omitted
+func (t *transImpl) HTTL(key string, hks ...string) (map[string]time.Duration, error) {
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+	return t.c.HTTL(key, hks...)
+}
+
+// HPersist remove expiration of hash keys
+func (t *transImpl) HPersist(key string, hks ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return err
+	}
+	return t.c.hPersist(t, key, hks...)
+}
+
+// func for streams
+
+// XAdd append message to stream, use "*" as id to let server generate it.
+// Inside a transaction the message is published on commit and the generated id is empty.
+func (t *transImpl) XAdd(key string, id string, values map[string]interface{}) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return "", err
+	}
+	return t.c.xAdd(t, key, id, values)
+}
+
+// XRange get messages with id between start and end, count <= 0 means no limit
+func (t *transImpl) XRange(key string, start string, end string, count int64) ([]driver.StreamMessage, error) {
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+	return t.c.XRange(key, start, end, count)
+}
+
+// XRead read messages after the given ids (stream key => id), block > 0 waits up to block for new messages
+func (t *transImpl) XRead(streams map[string]string, count int64, block time.Duration) ([]driver.Stream, error) {
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+	return t.c.XRead(streams, count, block)
+}
+
+// XGroupCreate create consumer group starting at id start, mkstream creates the stream if not exist
+func (t *transImpl) XGroupCreate(key string, group string, start string, mkstream bool) error {
+	if err := t.usable(); err != nil {
+		return err
+	}
+	return t.c.XGroupCreate(key, group, start, mkstream)
+}
+
+// XReadGroup read messages as consumer of group, use ">" as id to get messages never delivered
+func (t *transImpl) XReadGroup(group string, consumer string, streams map[string]string, count int64, block time.Duration) ([]driver.Stream, error) {
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+	return t.c.XReadGroup(group, consumer, streams, count, block)
+}
+
+// XAck acknowledge messages of group
+func (t *transImpl) XAck(key string, group string, ids ...string) (int64, error) {
+	if err := t.usable(); err != nil {
+		return 0, err
+	}
+	return t.c.XAck(key, group, ids...)
+}
+
+// XPending get pending messages of group, empty consumer means all consumers
+func (t *transImpl) XPending(key string, group string, start string, end string, count int64, consumer string) ([]driver.PendingMessage, error) {
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+	return t.c.XPending(key, group, start, end, count, consumer)
+}
+
+// XClaim take ownership of pending messages idle for at least minIdle
+func (t *transImpl) XClaim(key string, group string, consumer string, minIdle time.Duration, ids ...string) ([]driver.StreamMessage, error) {
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+	return t.c.XClaim(key, group, consumer, minIdle, ids...)
+}
+
+// func for pub/sub
+
+// Publish post message to channel, returns the number of clients received it.
+// Inside a transaction the message is published on commit and 0 is returned.
+func (t *transImpl) Publish(channel string, msg interface{}) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.checkWrite(); err != nil {
+		return 0, err
+	}
+	return t.c.publish(t, channel, msg)
+}
+
+// Subscribe subscribe channels
+func (t *transImpl) Subscribe(channels ...string) (driver.Subscription, error) {
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+	return t.c.Subscribe(channels...)
+}
+
+// PSubscribe subscribe channels matching patterns
+func (t *transImpl) PSubscribe(patterns ...string) (driver.Subscription, error) {
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+	return t.c.PSubscribe(patterns...)
+}
+
+// func for scripts
+
+// Eval run script, keys passed to the script are dropped from memory
+func (t *transImpl) Eval(script *driver.Script, keysAndArgs ...interface{}) (interface{}, error) {
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+	return t.c.Eval(script, keysAndArgs...)
+}
+
+// EvalSha run script loaded before by its SHA1 hash, keys passed to the script are dropped from memory
+func (t *transImpl) EvalSha(sha string, keyCount int, keysAndArgs ...interface{}) (interface{}, error) {
+	if err := t.usable(); err != nil {
+		return nil, err
+	}
+	return t.c.EvalSha(sha, keyCount, keysAndArgs...)
+}